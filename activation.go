@@ -0,0 +1,174 @@
+// Copyright 2013 Liviu G. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package goscgi
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// systemd socket activation: http://www.freedesktop.org/software/systemd/man/sd_listen_fds.html
+const (
+	listenFdsStart    = 3 // SD_LISTEN_FDS_START
+	listenPidKey      = "LISTEN_PID"
+	listenFdsKey      = "LISTEN_FDS"
+	listenFdNamesKey  = "LISTEN_FDNAMES"
+	unknownListenName = "unknown" // the name of the fds when LISTEN_FDNAMES is missing
+)
+
+var NoInheritedListenerErr = errors.New("No inherited listener")
+
+type inheritedListener struct {
+	name     string
+	listener net.Listener
+	taken    bool
+}
+
+var inherited struct {
+	once      sync.Once
+	mutex     sync.Mutex
+	listeners []*inheritedListener
+	err       error
+}
+
+// InheritedListeners returns the listening sockets passed to the process by systemd
+// (or any other launcher using the LISTEN_FDS, LISTEN_PID & LISTEN_FDNAMES variables)
+// or by Server.Upgrade,
+// grouped by their LISTEN_FDNAMES name; the name is "unknown" if LISTEN_FDNAMES is missing.
+// The variables are unset on the first call, so they are not passed to child processes.
+// The error reports the fds that couldn't be used as stream listeners (e.g. UDP sockets).
+func InheritedListeners() (map[string][]net.Listener, error) {
+	loadInheritedListeners()
+	inherited.mutex.Lock()
+	defer inherited.mutex.Unlock()
+	listeners := map[string][]net.Listener{}
+	for _, il := range inherited.listeners {
+		listeners[il.name] = append(listeners[il.name], il.listener)
+	}
+	return listeners, inherited.err
+}
+
+// InheritedListener returns the first inherited listener named name
+// that wasn't already returned by InheritedListener or used by ListenTcp/ListenUnix.
+func InheritedListener(name string) (net.Listener, error) {
+	listeners := takeInheritedListeners(func(il *inheritedListener) bool { return il.name == name }, true)
+	if len(listeners) == 0 {
+		return nil, NoInheritedListenerErr
+	}
+	return listeners[0], nil
+}
+
+// ServeInherited serves on all the inherited listeners named name (see InheritedListeners),
+// until the server is shut down. It returns NoInheritedListenerErr if there is no such listener.
+func (srv *Server) ServeInherited(name string) error {
+	listeners := takeInheritedListeners(func(il *inheritedListener) bool { return il.name == name }, false)
+	if len(listeners) == 0 {
+		return NoInheritedListenerErr
+	}
+	served := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			served <- srv.Serve(listener)
+		}(listener)
+	}
+	err := <-served
+	if err != ServerClosedErr {
+		// one listener failed: stop the others too
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}
+	for idx := 1; idx < len(listeners); idx++ {
+		<-served
+	}
+	return err
+}
+
+// inheritedTcpListener returns the inherited listener bound to addr, if any.
+func inheritedTcpListener(addr *net.TCPAddr) net.Listener {
+	listeners := takeInheritedListeners(func(il *inheritedListener) bool {
+		tcpAddr, ok := il.listener.Addr().(*net.TCPAddr)
+		return ok && tcpAddr.Port == addr.Port && (tcpAddr.IP.Equal(addr.IP) ||
+			(tcpAddr.IP.IsUnspecified() && (len(addr.IP) == 0 || addr.IP.IsUnspecified())))
+	}, true)
+	if len(listeners) == 0 {
+		return nil
+	}
+	return listeners[0]
+}
+
+// inheritedUnixListener returns the inherited listener bound to addr, if any.
+func inheritedUnixListener(addr *net.UnixAddr) net.Listener {
+	listeners := takeInheritedListeners(func(il *inheritedListener) bool {
+		unixAddr, ok := il.listener.Addr().(*net.UnixAddr)
+		return ok && unixAddr.Name == addr.Name
+	}, true)
+	if len(listeners) == 0 {
+		return nil
+	}
+	return listeners[0]
+}
+
+// takeInheritedListeners marks as taken & returns the free listeners matching filter.
+func takeInheritedListeners(filter func(*inheritedListener) bool, firstOnly bool) []net.Listener {
+	loadInheritedListeners()
+	inherited.mutex.Lock()
+	defer inherited.mutex.Unlock()
+	var listeners []net.Listener
+	for _, il := range inherited.listeners {
+		if !il.taken && filter(il) {
+			il.taken = true
+			listeners = append(listeners, il.listener)
+			if firstOnly {
+				break
+			}
+		}
+	}
+	return listeners
+}
+
+func loadInheritedListeners() {
+	inherited.once.Do(func() {
+		for _, key := range []string{listenPidKey, listenFdsKey, listenFdNamesKey, upgradeParentPidKey, upgradeReadyFdKey} {
+			defer os.Unsetenv(key)
+		}
+		if startedByUpgrade() {
+			loadUpgradeReady()
+		} else if pid, err := strconv.Atoi(os.Getenv(listenPidKey)); err != nil || pid != os.Getpid() {
+			return // not for us
+		}
+		count, err := strconv.Atoi(os.Getenv(listenFdsKey))
+		if err != nil || count <= 0 {
+			return
+		}
+		var names []string
+		if namesStr, ok := os.LookupEnv(listenFdNamesKey); ok {
+			names = strings.Split(namesStr, ":")
+		}
+		var failed []string
+		for idx := 0; idx < count; idx++ {
+			name := unknownListenName
+			if idx < len(names) {
+				name = names[idx]
+			}
+			fd := listenFdsStart + idx
+			file := os.NewFile(uintptr(fd), name)
+			listener, err := net.FileListener(file) // dups the fd
+			file.Close()
+			if err != nil {
+				failed = append(failed, "fd "+strconv.Itoa(fd)+" ("+name+"): "+err.Error())
+				continue
+			}
+			inherited.listeners = append(inherited.listeners, &inheritedListener{name: name, listener: listener})
+		}
+		if len(failed) > 0 {
+			inherited.err = errors.New("goscgi: invalid inherited listeners: " + strings.Join(failed, "; "))
+		}
+	})
+}
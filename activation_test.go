@@ -0,0 +1,159 @@
+// Copyright 2013 Liviu G. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package goscgi
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test_InheritedListeners starts the test binary as a child process running
+// Test_InheritedListenersChild, passing it a listener like systemd does.
+func Test_InheritedListeners(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	file, err := listener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	// LISTEN_PID must be the pid of the child: exec keeps the pid of the shell
+	cmd := exec.Command("/bin/sh", "-c", `LISTEN_PID=$$ exec "$0" -test.run=^Test_InheritedListenersChild$`, os.Args[0])
+	cmd.Env = append(os.Environ(), "GOSCGI_TEST_CHILD=activation", "LISTEN_FDS=1", "LISTEN_FDNAMES=web")
+	cmd.ExtraFiles = []*os.File{file}
+	cmd.Stderr = os.Stderr
+	if err = cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp := get(t, listener.Addr()); !strings.HasSuffix(resp, "\r\n\r\nhello from web") {
+		t.Errorf("unexpected response %q", resp)
+	}
+	if err = cmd.Wait(); err != nil {
+		t.Errorf("child process: %v", err)
+	}
+}
+
+func Test_InheritedListenersChild(t *testing.T) {
+	if os.Getenv("GOSCGI_TEST_CHILD") != "activation" {
+		t.Skip("runs only as a child process of Test_InheritedListeners")
+	}
+	listeners, err := InheritedListeners()
+	if err != nil || len(listeners["web"]) != 1 {
+		t.Fatalf("expected one listener named web, got %v, %v", listeners, err)
+	}
+	if len(os.Getenv(listenFdsKey)) > 0 {
+		t.Error(listenFdsKey + " should be unset")
+	}
+
+	srv := NewServer(NewSettings())
+	srv.AddHandler("/", func(req *Request) *Response {
+		defer func() { go srv.Shutdown(context.Background()) }()
+		return NewResponse(RespCodeOK, RespTypeText, []byte("hello from web"))
+	})
+	if err = srv.ServeInherited("web"); err != ServerClosedErr {
+		t.Errorf("expected ServerClosedErr, got %v", err)
+	}
+	if err = srv.ServeInherited("web"); err != NoInheritedListenerErr {
+		t.Errorf("the listener should be served only once, got %v", err)
+	}
+}
+
+// Test_Upgrade upgrades to a new process running Test_UpgradeChild.
+func Test_Upgrade(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := NewSettings()
+	settings.UpgradeTimeout = 0 // the default
+	srv := NewServer(settings)
+	srv.AddHandler("/", func(req *Request) *Response {
+		return NewResponse(RespCodeOK, RespTypeText, []byte("hello from parent"))
+	})
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(listener) }()
+	if resp := get(t, listener.Addr()); !strings.HasSuffix(resp, "\r\n\r\nhello from parent") {
+		t.Errorf("unexpected response %q", resp)
+	}
+
+	t.Setenv("GOSCGI_TEST_CHILD", "upgrade")
+	t.Setenv("GOSCGI_TEST_ADDR", listener.Addr().String())
+	statusPath := t.TempDir() + "/status"
+	t.Setenv("GOSCGI_TEST_STATUS", statusPath)
+	args := os.Args
+	defer func() { os.Args = args }()
+	os.Args = []string{args[0], "-test.run=^Test_UpgradeChild$"}
+	if err = srv.Upgrade(); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-served; err != ServerClosedErr {
+		t.Errorf("expected ServerClosedErr, got %v", err)
+	}
+
+	// the listener was closed by the parent, the new process accepts on its copy
+	if resp := get(t, listener.Addr()); !strings.HasSuffix(resp, "\r\n\r\nhello from child") {
+		t.Errorf("unexpected response %q", resp)
+	}
+
+	// the child is reaped by Upgrade, it writes its result in the status file
+	deadline := time.Now().Add(10 * time.Second)
+	status, err := os.ReadFile(statusPath)
+	for ; err != nil && time.Now().Before(deadline); status, err = os.ReadFile(statusPath) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Errorf("Test_UpgradeChild didn't report its status: %v", err)
+	} else if string(status) != "PASS" {
+		t.Errorf("Test_UpgradeChild: %s, see its output", status)
+	}
+}
+
+// get sends a GET / request to addr & returns the response.
+func get(t *testing.T, addr net.Addr) string {
+	conn, err := net.Dial(addr.Network(), addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	sendRequest(conn, map[string]string{"REQUEST_URI": "/", "REQUEST_METHOD": "GET"}, "")
+	resp, _ := io.ReadAll(conn)
+	return string(resp)
+}
+
+func Test_UpgradeChild(t *testing.T) {
+	if os.Getenv("GOSCGI_TEST_CHILD") != "upgrade" {
+		t.Skip("runs only as a child process of Test_Upgrade")
+	}
+	defer func() {
+		status := "PASS"
+		if t.Failed() {
+			status = "FAIL"
+		}
+		statusPath := os.Getenv("GOSCGI_TEST_STATUS")
+		if os.WriteFile(statusPath+".tmp", []byte(status), 0o600) == nil {
+			os.Rename(statusPath+".tmp", statusPath) // the parent never reads a partial status
+		}
+	}()
+	srv := NewServer(NewSettings())
+	srv.AddHandler("/", func(req *Request) *Response {
+		defer func() { go srv.Shutdown(context.Background()) }()
+		return NewResponse(RespCodeOK, RespTypeText, []byte("hello from child"))
+	})
+	// the address is still bound by the parent, so it works only with the inherited listener
+	if err := srv.ListenTcp(os.Getenv("GOSCGI_TEST_ADDR")); err != ServerClosedErr {
+		t.Errorf("expected ServerClosedErr, got %v", err)
+	}
+}
@@ -17,6 +17,7 @@ func main() {
 	runtime.GOMAXPROCS(runtime.NumCPU())
 	serv := scgi.NewServer(scgi.NewSettings())
 	serv.AddHandler("/ajax", ajax)
+	serv.AddHandler("/ajax/", ajax) // the paths below /ajax too
 	serv.AddHandler("/", index)
 
 	err := serv.ListenTcp(":8080")
@@ -87,9 +88,10 @@ func writeRequestInfo(req *scgi.Request, buff *bytes.Buffer) {
 			buff.WriteString(k + " = " + html.EscapeString(value) + "<br/>\r\n")
 		}
 	}
-	if req.ContentSize > 0 && len(req.Content) > 0 {
+	// req.Body streams the content; for forms it reads the buffered req.Content
+	if content, err := ioutil.ReadAll(req.Body); err == nil && len(content) > 0 {
 		buff.WriteString("<hr/><h3> Raw content: </h3>")
-		buff.WriteString(html.EscapeString(string(req.Content)))
+		buff.WriteString(html.EscapeString(string(content)))
 	}
 }
 
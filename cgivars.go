@@ -0,0 +1,133 @@
+// Copyright 2013 Liviu G. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package goscgi
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// The CGI/1.1 meta-variables (RFC 3875, section 4.1) and those added by nginx's scgi_params.
+// The raw values are in req.Header, e.g. req.Header.Get(ServerNameKey).
+
+// IsTLS reports if the request was received over TLS (HTTPS=on or REQUEST_SCHEME=https).
+func (req *Request) IsTLS() bool {
+	https := req.Header.Get(HttpsKey)
+	return strings.EqualFold(https, "on") || https == "1" || strings.EqualFold(req.Header.Get(RequestSchemeKey), "https")
+}
+
+// Scheme returns "https" or "http".
+func (req *Request) Scheme() string {
+	if req.IsTLS() {
+		return "https"
+	}
+	return "http"
+}
+
+// Host returns the host requested by the client, from the Host header
+// or SERVER_NAME, without the port.
+func (req *Request) Host() string {
+	host := req.Header.Get(HttpHostKey)
+	if len(host) == 0 {
+		return req.Header.Get(ServerNameKey)
+	}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		return hostname
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
+
+// Port returns SERVER_PORT, the default port of the scheme if it's missing or invalid.
+func (req *Request) Port() int {
+	if port, err := strconv.Atoi(req.Header.Get(ServerPortKey)); err == nil && port > 0 && port <= 65535 {
+		return port
+	}
+	if req.IsTLS() {
+		return 443
+	}
+	return 80
+}
+
+// Proto returns SERVER_PROTOCOL, e.g. "HTTP/1.1"; "HTTP/1.0" if missing.
+func (req *Request) Proto() string {
+	if proto := req.Header.Get(ServerProtoKey); len(proto) > 0 {
+		return proto
+	}
+	return "HTTP/1.0"
+}
+
+// RemoteAddr returns the client address, from REMOTE_ADDR & REMOTE_PORT;
+// the zero AddrPort (!IsValid()) if REMOTE_ADDR is missing or invalid.
+func (req *Request) RemoteAddr() netip.AddrPort {
+	addr, err := netip.ParseAddr(req.Header.Get(RemoteAddrKey))
+	if err != nil {
+		return netip.AddrPort{}
+	}
+	port, _ := strconv.ParseUint(req.Header.Get(RemotePortKey), 10, 16)
+	return netip.AddrPortFrom(addr.Unmap(), uint16(port))
+}
+
+// RemoteUser returns the authenticated user name, if the web server authenticated the client.
+func (req *Request) RemoteUser() string {
+	return req.Header.Get(RemoteUserKey)
+}
+
+// ScriptName returns the path of the application, SCRIPT_NAME.
+func (req *Request) ScriptName() string {
+	return req.Header.Get(ScriptNameKey)
+}
+
+// PathInfo returns the path following SCRIPT_NAME, PATH_INFO.
+func (req *Request) PathInfo() string {
+	return req.Header.Get(PathInfoKey)
+}
+
+// DocumentRoot returns the web server's root directory, DOCUMENT_ROOT.
+func (req *Request) DocumentRoot() string {
+	return req.Header.Get(DocumentRootKey)
+}
+
+// Upgrade returns the protocols the client asks to switch to, HTTP_UPGRADE
+// (e.g. "websocket"); "" if none.
+func (req *Request) Upgrade() string {
+	return req.Header.Get(HttpUpgradeKey)
+}
+
+// DocumentURI returns the normalized path of the request, without the query string;
+// nginx's DOCUMENT_URI, or the path of the request URI.
+func (req *Request) DocumentURI() string {
+	if uri := req.Header.Get(DocumentUriKey); len(uri) > 0 {
+		return uri
+	}
+	if req.URL != nil {
+		return req.URL.Path
+	}
+	return ""
+}
+
+// HTTPHeader rebuilds the HTTP request headers from the HTTP_* variables
+// (HTTP_X_FORWARDED_FOR -> X-Forwarded-For), CONTENT_TYPE & CONTENT_LENGTH.
+// The underscores can't be told apart from dashes, all become dashes.
+func (req *Request) HTTPHeader() http.Header {
+	header := http.Header{}
+	for name, values := range req.Header {
+		name = strings.ToUpper(name) // Header canonicalized the names
+		switch {
+		case strings.HasPrefix(name, "HTTP_") && len(name) > len("HTTP_"):
+			name = strings.ReplaceAll(name[len("HTTP_"):], "_", "-")
+		case name == ContentTypeKey:
+			name = "Content-Type"
+		case name == ContentSizeKey:
+			name = "Content-Length"
+		default:
+			continue
+		}
+		header[http.CanonicalHeaderKey(name)] = append([]string(nil), values...)
+	}
+	return header
+}
@@ -0,0 +1,49 @@
+// Copyright 2013 Liviu G. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package goscgi_test
+
+import (
+	"fmt"
+	"goscgi"
+	"goscgi/scgitest"
+	"testing"
+)
+
+func Test_MetaVariables(t *testing.T) {
+	req, err := scgitest.NewRequest("POST", "/app/users/7?x=1").
+		Param(goscgi.HttpsKey, "on").
+		Param(goscgi.ServerPortKey, "8443").
+		Param(goscgi.RemoteAddrKey, "::ffff:10.0.0.7").
+		Param(goscgi.RemotePortKey, "51234").
+		Param(goscgi.ScriptNameKey, "/app").
+		Param(goscgi.PathInfoKey, "/users/7").
+		Param(goscgi.RemoteUserKey, "arthur").
+		Header("Host", "example.com:8443").
+		Header("X-Forwarded-For", "192.0.2.1").
+		Header("Upgrade", "websocket").
+		Content("text/plain", []byte("42")).
+		Build(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := fmt.Sprint(req.Scheme(), " ", req.Host(), " ", req.Port(), " ", req.Proto(), " ", req.RemoteAddr(), " ",
+		req.RemoteUser(), " ", req.ScriptName(), " ", req.PathInfo(), " ", req.DocumentURI(), " ", req.Upgrade())
+	expected := "https example.com 8443 HTTP/1.1 10.0.0.7:51234 arthur /app /users/7 /app/users/7 websocket"
+	if got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+	header := req.HTTPHeader()
+	if header.Get("X-Forwarded-For") != "192.0.2.1" || header.Get("Host") != "example.com:8443" ||
+		header.Get("Content-Type") != "text/plain" || header.Get("Content-Length") != "2" || len(header) != 5 {
+		t.Errorf("unexpected HTTP header %v", header)
+	}
+
+	if req, err = scgitest.NewRequest("GET", "/").Param(goscgi.RemoteAddrKey, "unix:").Build(nil); err != nil {
+		t.Fatal(err)
+	}
+	if req.RemoteAddr().IsValid() || req.Scheme() != "http" || req.Port() != 80 || req.Host() != "localhost" {
+		t.Errorf("unexpected defaults %v %s %d %s", req.RemoteAddr(), req.Scheme(), req.Port(), req.Host())
+	}
+}
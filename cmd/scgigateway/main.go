@@ -0,0 +1,39 @@
+// Copyright 2013 Liviu G. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// scgigateway is a minimal HTTP to SCGI gateway, replacing nginx during development:
+//
+//	scgigateway -listen :8000 -backend 127.0.0.1:8080
+//	scgigateway -listen :8000 -backend unix:/tmp/goscgi.socket
+//
+// Each HTTP request is sent to the backend with the variables nginx sends
+// with "include scgi_params"; the CGI response is relayed back as it arrives.
+package main
+
+import (
+	"flag"
+	"goscgi/scgiproxy"
+	"log"
+	"net/http"
+)
+
+func main() {
+	listen := flag.String("listen", ":8000", "the HTTP address to listen on")
+	backend := flag.String("backend", "127.0.0.1:8080", "the SCGI backend: host:port or unix:/path/to/socket")
+	documentRoot := flag.String("root", "", "the DOCUMENT_ROOT sent to the backend")
+	flag.Parse()
+
+	proxy := scgiproxy.NewProxy(scgiproxy.RoundRobin, *backend)
+	proxy.MaxFails = 0 // a single backend: never eject it
+	if len(*documentRoot) > 0 {
+		proxy.Backends[0].Client.Params = map[string]string{"DOCUMENT_ROOT": *documentRoot}
+	}
+
+	log.Println("listening for HTTP connections at", *listen, "-> SCGI", proxy.Backends[0].String())
+	log.Println("press ctrl + c to close...")
+	err := http.ListenAndServe(*listen, proxy)
+	if err != nil {
+		log.Println(err.Error())
+	}
+}
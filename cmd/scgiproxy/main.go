@@ -0,0 +1,74 @@
+// Copyright 2013 Liviu G. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// scgiproxy is an HTTP front proxy balancing the requests over several SCGI backends:
+//
+//	scgiproxy -listen :8000 -policy least-conn -health /health 127.0.0.1:8081 127.0.0.1:8082 unix:/tmp/goscgi.socket
+//
+// A backend is ejected after -max-fails consecutive connection failures, for -eject-time
+// or until it passes a health check. The idempotent requests without content are
+// retried on another backend when the connection fails.
+package main
+
+import (
+	"context"
+	"flag"
+	"goscgi/scgiproxy"
+	"log"
+	"net/http"
+	"os"
+)
+
+func main() {
+	listen := flag.String("listen", ":8000", "the HTTP address to listen on")
+	policy := flag.String("policy", "round-robin", "the balancing policy: round-robin or least-conn")
+	healthPath := flag.String("health", "/health", `the path requested to check the backends' health; "" = no checks`)
+	healthInterval := flag.Duration("health-interval", 0, "the interval between health checks (default 5s)")
+	maxFails := flag.Int("max-fails", 3, "the consecutive connection failures ejecting a backend; 0 = never eject")
+	ejectTime := flag.Duration("eject-time", 0, "how long an ejected backend is skipped (default 10s)")
+	retries := flag.Int("retries", 1, "how many other backends are tried for the idempotent requests")
+	documentRoot := flag.String("root", "", "the DOCUMENT_ROOT sent to the backends")
+	flag.Usage = func() {
+		log.SetFlags(0)
+		log.Println("usage: scgiproxy [flags] backend...\n  backend: host:port or unix:/path/to/socket")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	proxy := scgiproxy.NewProxy(scgiproxy.RoundRobin, flag.Args()...)
+	switch *policy {
+	case "round-robin":
+	case "least-conn":
+		proxy.Policy = scgiproxy.LeastConnections
+	default:
+		log.Fatalln("unknown policy", *policy)
+	}
+	proxy.HealthPath = *healthPath
+	if *healthInterval > 0 {
+		proxy.HealthInterval = *healthInterval
+	}
+	proxy.MaxFails = *maxFails
+	if *ejectTime > 0 {
+		proxy.EjectTime = *ejectTime
+	}
+	proxy.Retries = *retries
+	for _, backend := range proxy.Backends {
+		if len(*documentRoot) > 0 {
+			backend.Client.Params = map[string]string{"DOCUMENT_ROOT": *documentRoot}
+		}
+		log.Println("backend", backend.String())
+	}
+	go proxy.CheckHealth(context.Background())
+
+	log.Println("listening for HTTP connections at", *listen)
+	log.Println("press ctrl + c to close...")
+	err := http.ListenAndServe(*listen, proxy)
+	if err != nil {
+		log.Println(err.Error())
+	}
+}
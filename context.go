@@ -0,0 +1,70 @@
+// Copyright 2013 Liviu G. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package goscgi
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// newRequestContext returns the context of a request handled by handler (nil if not found).
+// It's cancelled by the returned func, when Shutdown gives up waiting or when the handler timeout expires.
+func (srv *Server) newRequestContext(serverCtx context.Context, handler *Handler) (context.Context, context.CancelFunc) {
+	timeout := srv.Settings.HandlerTimeout
+	if handler != nil && handler.Timeout > 0 {
+		timeout = handler.Timeout
+	}
+	if timeout > 0 {
+		return context.WithTimeout(serverCtx, timeout)
+	}
+	return context.WithCancel(serverCtx)
+}
+
+// peerWatcher cancels the request context when the peer (e.g. nginx) closes the connection.
+// It reads from the connection, so it can be started only after all the content was read.
+// A peer that only shuts down its write side (e.g. net.TCPConn.CloseWrite) after sending
+// the request can't be told apart from a closed connection: the context is cancelled,
+// but the response is still written.
+type peerWatcher struct {
+	conn    net.Conn
+	cancel  context.CancelFunc
+	mutex   sync.Mutex
+	started bool
+}
+
+// watchPeer starts watching right away if the content was already read,
+// otherwise when req.Body reaches its end.
+func watchPeer(req *Request, cancel context.CancelFunc) {
+	watcher := &peerWatcher{conn: req.Connection, cancel: cancel}
+	if body, ok := req.Body.(*contentReader); ok && body.remaining > 0 {
+		body.onEnd = watcher.start
+	} else {
+		watcher.start()
+	}
+}
+
+func (watcher *peerWatcher) start() {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+	if watcher.started {
+		return
+	}
+	watcher.started = true
+	watcher.conn.SetReadDeadline(time.Time{})
+	go func() {
+		// the peer doesn't send anything after the content, so the read
+		// fails only when the connection is closed (by the peer or by us)
+		// or when the peer shuts down its write side
+		var buff [1]byte
+		for {
+			if _, err := watcher.conn.Read(buff[:]); err != nil {
+				watcher.cancel()
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,163 @@
+// Copyright 2013 Liviu G. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package goscgi
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+)
+
+// ErrorKind classifies the errors occurring while reading a request,
+// each kind is answered with its own status.
+type ErrorKind int
+
+const (
+	MalformedRequest ErrorKind = iota // 400 Bad Request
+	ContentTooLarge                   // 413 Content Too Large
+	HeaderTooLarge                    // 431 Request Header Fields Too Large
+	UnknownMethod                     // 501 Not Implemented: the method is not a valid token
+	RequestTimeout                    // 408 Request Timeout
+	PeerGone                          // the connection was closed or failed: no response
+)
+
+var kindNames = []string{
+	MalformedRequest: "malformed request",
+	ContentTooLarge:  "content too large",
+	HeaderTooLarge:   "header too large",
+	UnknownMethod:    "unknown method",
+	RequestTimeout:   "request timeout",
+	PeerGone:         "peer gone",
+}
+
+func (kind ErrorKind) String() string {
+	if kind < 0 || int(kind) >= len(kindNames) {
+		return fmt.Sprintf("ErrorKind(%d)", int(kind))
+	}
+	return kindNames[kind]
+}
+
+// ProtocolError describes why & where a request couldn't be read.
+// Err is the cause: one of the error variables of this package or
+// the error returned by the connection or a parser.
+type ProtocolError struct {
+	Kind   ErrorKind
+	Name   string // the offending header, if any
+	Offset int    // the offset in the request where the error was found; -1 = unknown
+	Err    error
+}
+
+func (err *ProtocolError) Error() string {
+	msg := "SCGI protocol error"
+	if err.Kind != MalformedRequest {
+		msg += " (" + err.Kind.String() + ")"
+	}
+	if err.Offset >= 0 {
+		msg += fmt.Sprintf(" at byte %d", err.Offset)
+	}
+	if len(err.Name) > 0 {
+		msg += fmt.Sprintf(", header %q", err.Name)
+	}
+	return msg + ": " + err.Err.Error()
+}
+
+func (err *ProtocolError) Unwrap() error {
+	return err.Err
+}
+
+// newConnError classifies an error returned by the connection.
+func newConnError(err error, offset int) *ProtocolError {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return &ProtocolError{Kind: RequestTimeout, Offset: offset, Err: err}
+	}
+	return &ProtocolError{Kind: PeerGone, Offset: offset, Err: err}
+}
+
+// newHeaderError describes an invalid header value.
+func newHeaderError(kind ErrorKind, name string, err error) *ProtocolError {
+	return &ProtocolError{Kind: kind, Name: name, Offset: -1, Err: err}
+}
+
+// errorResponse returns the response to a request that couldn't be read because of err,
+// nil if the peer is gone. accept is the request's Accept header, if known (see newErrorResponse).
+func errorResponse(err error, accept string) *Response {
+	status := http.StatusBadRequest
+	var protocolErr *ProtocolError
+	if errors.As(err, &protocolErr) {
+		if protocolErr.Kind == PeerGone {
+			return nil
+		}
+		status = protocolErr.StatusCode()
+	}
+	return newErrorResponse(status, err.Error(), accept)
+}
+
+// StatusError is an error carrying the HTTP status of its response,
+// like *Problem, *JSONError & *ProtocolError.
+type StatusError interface {
+	error
+	StatusCode() int
+}
+
+// StatusCode returns the status answering the error; PeerGone has no response, it returns 400.
+func (err *ProtocolError) StatusCode() int {
+	switch err.Kind {
+	case ContentTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case HeaderTooLarge:
+		return http.StatusRequestHeaderFieldsTooLarge
+	case UnknownMethod:
+		return http.StatusNotImplemented
+	case RequestTimeout:
+		return http.StatusRequestTimeout
+	}
+	return http.StatusBadRequest
+}
+
+// DefaultErrorHandler logs err with the request method, URI & remote address and
+// returns its response: a *Problem or *JSONError as such, a StatusError with its status,
+// any other error as 500. The details of the 5xx errors are not sent to the client
+// (the Detail of a 5xx *Problem is removed, a 5xx *JSONError is answered like a StatusError).
+// The responses are problem details if the Accept header prefers them, see Problem.
+func DefaultErrorHandler(req *Request, err error) *Response {
+	log.Println("goscgi.DefaultErrorHandler:", req.Method, req.RawURI, req.Header.Get(RemoteAddrKey), err.Error())
+	var problem *Problem
+	var jsonErr *JSONError
+	var statusErr StatusError
+	switch {
+	case errors.As(err, &problem):
+		if problem.Status >= http.StatusInternalServerError && len(problem.Detail) > 0 {
+			withoutDetail := *problem
+			withoutDetail.Detail = ""
+			problem = &withoutDetail
+		}
+		return problem.Response()
+	case errors.As(err, &jsonErr) && jsonErr.Status < http.StatusInternalServerError:
+		return jsonErr.Response()
+	case errors.As(err, &statusErr) && ValidStatus(statusErr.StatusCode()):
+		var detail string
+		if statusErr.StatusCode() < http.StatusInternalServerError {
+			detail = err.Error()
+		}
+		return newErrorResponse(statusErr.StatusCode(), detail, req.Header.Get(HttpAcceptKey))
+	}
+	return newErrorResponse(http.StatusInternalServerError, "", req.Header.Get(HttpAcceptKey))
+}
+
+// handleError calls the ErrorHandler of srv, which may be nil
+// for the requests not received by a server (e.g. in tests).
+func (srv *Server) handleError(req *Request, err error) *Response {
+	errorHandler := DefaultErrorHandler
+	if srv != nil && srv.ErrorHandler != nil {
+		errorHandler = srv.ErrorHandler
+	}
+	if resp := errorHandler(req, err); resp != nil {
+		return resp
+	}
+	return RespInternalError
+}
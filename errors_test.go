@@ -0,0 +1,79 @@
+// Copyright 2013 Liviu G. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package goscgi_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"goscgi"
+	"goscgi/scgiclient"
+	"goscgi/scgitest"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_ProtocolErrors(t *testing.T) {
+	settings := goscgi.NewSettings()
+	settings.MaxHeaderSize = 300
+	settings.MaxContentSize = 10
+	settings.MaxBodySize = 20
+	settings.ReadTimeout = 50 * time.Millisecond
+	srv := goscgi.NewServer(settings)
+	srv.AddHandler("/upload", func(req *goscgi.Request) *goscgi.Response {
+		t.Error("the handler was called for a content over MaxBodySize")
+		return goscgi.NewResponse(goscgi.RespCodeOK, goscgi.RespTypeText, nil)
+	})
+	srv.AddHandler("/", func(req *goscgi.Request) *goscgi.Response {
+		return goscgi.NewResponse(goscgi.RespCodeOK, goscgi.RespTypeText, []byte(req.Form.Get("name")))
+	})
+	listener := scgitest.Start(srv)
+	defer srv.Shutdown(context.Background())
+
+	tests := []struct {
+		req    *scgitest.RequestBuilder
+		status int
+	}{
+		{scgitest.NewRequest("GET", "/").Header("X-Long", strings.Repeat("x", 300)), http.StatusRequestHeaderFieldsTooLarge},
+		{scgitest.NewRequest("POST", "/").Form(url.Values{"name": {"Zaphod Beeblebrox"}}), http.StatusRequestEntityTooLarge},
+		{scgitest.NewRequest("PUT", "/upload").Content("text/plain", []byte("21 bytes of streaming")), http.StatusRequestEntityTooLarge},
+		{scgitest.NewRequest("B@D", "/"), http.StatusNotImplemented},
+		{scgitest.NewRequest("GET", "%zz"), http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		resp, _, err := listener.Fetch(test.req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: expected %d, got %d", test.req.Params()[goscgi.RequestUriKey], test.status, resp.StatusCode)
+		}
+	}
+
+	// a header that never ends times out
+	conn, err := listener.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("100:CONTENT_LENGTH"))
+	resp, err := scgiclient.ReadResponse(bufio.NewReader(conn), "GET")
+	if err != nil || resp.StatusCode != http.StatusRequestTimeout {
+		t.Errorf("expected a timeout response, got %v %v", resp, err)
+	}
+
+	var protocolErr *goscgi.ProtocolError
+	_, err = scgitest.NewRequest("POST", "/").Form(url.Values{"name": {"Zaphod Beeblebrox"}}).Build(settings)
+	if !errors.As(err, &protocolErr) || protocolErr.Kind != goscgi.ContentTooLarge || !errors.Is(err, goscgi.InvalidContentErr) {
+		t.Errorf("expected a ContentTooLarge error, got %v", err)
+	}
+	_, err = scgitest.NewRequest("B@D", "/").Build(nil)
+	if !errors.As(err, &protocolErr) || protocolErr.Kind != goscgi.UnknownMethod || protocolErr.Name != goscgi.RequestMethodKey {
+		t.Errorf("expected an UnknownMethod error, got %v", err)
+	}
+}
@@ -5,120 +5,515 @@
 package goscgi
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"mime/multipart"
 	"net"
+	"net/http"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
 
-// not a real test :|
-func Test_All(t *testing.T) {
-	log.Println("test starting..")
+func requestHandler(req *Request) *Response {
+	return NewResponse(RespCodeOK, RespTypeHtml, []byte("this is a test response"))
+}
 
-	srv := NewServer(NewSettings())
-	srv.AddHandler("/cgi/", requestHandler)
+// http://www.python.ca/scgi/protocol.txt
+func sendRequest(conn net.Conn, header map[string]string, content string) {
+	if len(content) > 0 {
+		header["CONTENT_LENGTH"] = strconv.Itoa(len(content))
+	}
 
-	go startServer(srv)
-	time.Sleep(time.Second)
+	headerSize := 0
+	for k, v := range header {
+		headerSize += len(k) + 1 // include the separator byte
+		headerSize += len(v) + 1
+	}
+	headerSizeStr := strconv.Itoa(headerSize)
 
-	startClient()
+	conn.Write([]byte(headerSizeStr))
+	fmt.Fprint(conn, ":")
 
-	// signal server to stop listening but don't wait
-	close(srv.Close)
+	zero := []byte{0}
+	for k, v := range header {
+		fmt.Fprint(conn, k)
+		conn.Write(zero)
+		fmt.Fprint(conn, v)
+		conn.Write(zero)
+	}
 
-	log.Println("test done")
+	fmt.Fprint(conn, ",")
+	fmt.Fprint(conn, content)
 }
 
-func startServer(srv *Server) {
-	err := srv.ListenTcp(":8080")
+func Test_Serve(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
-		log.Println("startServer, srv.ListenTcp", err.Error())
+		t.Fatal(err)
 	}
-}
+	defer listener.Close()
+	go Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Path", r.URL.Path)
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.Query().Get("arg1"), body)
+	}))
 
-func requestHandler(req *Request) *Response {
-	log.Println()
-	log.Println("handling request:")
-	log.Println(req.URL.Path)
-	log.Println(req.ContentType)
-	for k, v := range req.Query {
-		log.Println(k, " = ", v)
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
 	}
-	for k, v := range req.Form {
-		log.Println(k, " = ", v)
+	defer conn.Close()
+	header := map[string]string{
+		"REQUEST_URI":    "/cgi/test.cgi?arg1=val1",
+		"REQUEST_METHOD": "POST",
+		"CONTENT_TYPE":   "text/plain",
 	}
-	for k, v := range req.Header {
-		log.Println(k, " = ", v)
+	sendRequest(conn, header, "content")
+	resp, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
 	}
-	if req.ContentSize > 0 && len(req.Content) > 0 {
-		log.Println(string(req.Content))
+	for _, expected := range []string{"Status: 201 Created\r\n", "X-Path: /cgi/test.cgi\r\n", "Content-Length: 17\r\n", "\r\n\r\nPOST val1 content"} {
+		if !strings.Contains(string(resp), expected) {
+			t.Errorf("response %q does not contain %q", resp, expected)
+		}
 	}
 
-	return NewResponse(RespCodeOK, RespTypeHtml, []byte("this is a test response"))
+	// HEAD: the same headers, without the content
+	headConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer headConn.Close()
+	sendRequest(headConn, map[string]string{"REQUEST_URI": "/cgi/test.cgi?arg1=val1", "REQUEST_METHOD": "HEAD"}, "")
+	if resp, err = io.ReadAll(headConn); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(resp), "Content-Length: 10\r\n") || !strings.HasSuffix(string(resp), "\r\n\r\n") {
+		t.Errorf("unexpected HEAD response %q", resp)
+	}
 }
 
-func startClient() {
-	conn, err := net.Dial("tcp", "localhost:8080")
+func Test_HTTPServerPanic(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
-		log.Println("startClient, net.Dial:", err.Error())
-		return
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	panics := make(chan string, 1)
+	srv := &HTTPServer{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/abort" {
+				panic(http.ErrAbortHandler)
+			}
+			panic("http boom")
+		}),
+		PanicHandler: func(req *http.Request, value any, stack []byte) {
+			panics <- req.URL.Path + " " + fmt.Sprint(value)
+		},
+	}
+	go srv.Serve(listener)
+
+	for _, path := range []string{"/abort", "/boom"} {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		sendRequest(conn, map[string]string{"REQUEST_URI": path, "REQUEST_METHOD": "GET"}, "")
+		resp, _ := io.ReadAll(conn)
+		if expected := "Status: 500 Internal Server Error\r\n"; path == "/boom" && !strings.HasPrefix(string(resp), expected) {
+			t.Errorf("%s: expected %q, got %q", path, expected, resp)
+		} else if path == "/abort" && len(resp) > 0 {
+			t.Errorf("%s: expected no response, got %q", path, resp)
+		}
+	}
+	if reported := <-panics; reported != "/boom http boom" {
+		t.Errorf("unexpected panic %q", reported)
+	}
+}
+
+func Test_HTTPServerPanicHandlerPanic(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	srv := &HTTPServer{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("http boom")
+		}),
+		PanicHandler: func(req *http.Request, value any, stack []byte) {
+			panic("tracker down")
+		},
+	}
+	go srv.Serve(listener)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
 	}
 	defer conn.Close()
+	sendRequest(conn, map[string]string{"REQUEST_URI": "/boom", "REQUEST_METHOD": "GET"}, "")
+	resp, _ := io.ReadAll(conn)
+	if expected := "Status: 500 Internal Server Error\r\n"; !strings.HasPrefix(string(resp), expected) {
+		t.Errorf("expected %q, got %q", expected, resp)
+	}
+}
+
+func Test_StreamResponse(t *testing.T) {
+	server, client := net.Pipe()
+	go func() {
+		resp := NewStreamResponse(RespCodeOK, RespTypeText, func(w *ResponseWriter) error {
+			for idx := 0; idx < 3; idx++ {
+				fmt.Fprintf(w, "chunk %d;", idx)
+				if err := w.Flush(); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err := resp.Write(server, time.Second); err != nil {
+			t.Error(err)
+		}
+		server.Close()
+	}()
+	resp, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "Status: 200 OK\r\nContent-Type: text/plain\r\n\r\nchunk 0;chunk 1;chunk 2;"
+	if string(resp) != expected {
+		t.Errorf("expected %q, got %q", expected, resp)
+	}
+}
 
-	header := make(map[string]string)
-	header["CONTENT_LENGTH"] = "" // will be computed later
-	header["REQUEST_URI"] = "/cgi/test.cgi?arg1=val1&arg2=val2"
-	header["REQUEST_METHOD"] = "GET"
-	header["CONTENT_TYPE"] = "text/plain"
-	content := "this is a test request"
+func Test_RequestBody(t *testing.T) {
+	for _, bufferContent := range []bool{false, true} {
+		server, client := net.Pipe()
+		go sendRequest(client, map[string]string{
+			"REQUEST_URI":    "/upload",
+			"REQUEST_METHOD": "PUT",
+			"CONTENT_TYPE":   "application/octet-stream",
+		}, "some streamed content")
+		settings := NewSettings()
+		settings.BufferContent = bufferContent
+		req, err := ReadRequest(server, settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bufferContent != (len(req.Content) > 0) {
+			t.Errorf("BufferContent = %v but len(req.Content) = %d", bufferContent, len(req.Content))
+		}
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != "some streamed content" {
+			t.Errorf("BufferContent = %v: unexpected content %q", bufferContent, body)
+		}
+		server.Close()
+		client.Close()
+	}
 
-	// we send an SCGI request
-	sendRequest(conn, header, content)
-	time.Sleep(time.Second)
+	// the streamed content isn't limited by MaxContentSize, but by MaxBodySize, before it's read
+	for _, maxBodySize := range []int64{0, 4} {
+		server, client := net.Pipe()
+		go sendRequest(client, map[string]string{
+			"REQUEST_URI":    "/upload",
+			"REQUEST_METHOD": "PUT",
+			"CONTENT_TYPE":   "application/octet-stream",
+		}, "some streamed content")
+		settings := NewSettings()
+		settings.MaxContentSize = 4
+		settings.MaxBodySize = maxBodySize
+		req, err := ReadRequest(server, settings)
+		var protocolErr *ProtocolError
+		if maxBodySize > 0 {
+			if !errors.As(err, &protocolErr) || protocolErr.Kind != ContentTooLarge {
+				t.Errorf("MaxBodySize = %d: expected a ContentTooLarge error, got %v", maxBodySize, err)
+			}
+		} else if err != nil {
+			t.Fatal(err)
+		} else if body, err := io.ReadAll(req.Body); string(body) != "some streamed content" || err != nil {
+			t.Errorf("MaxBodySize = %d: unexpected content %q %v", maxBodySize, body, err)
+		}
+		server.Close()
+		client.Close()
+	}
+}
 
-	log.Println()
-	log.Println("server response:")
+func Test_UnreadContentDrained(t *testing.T) {
+	settings := NewSettings()
+	settings.MaxBodySize = 4 * 1024 * 1024
+	srv := NewServer(settings)
+	srv.AddHandler("/ignore", func(req *Request) *Response {
+		return NewResponse(RespCodeOK, RespTypeText, []byte("ignored"))
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
 
-	var buff [80]byte
-	for {
-		readCnt, _ := conn.Read(buff[:])
-		if readCnt == 0 {
-			return
+	// unread data makes the kernel reset the connection on close, the response must end with EOF
+	tests := []struct {
+		size     int
+		expected string
+	}{
+		{3 * 1024 * 1024, "\r\n\r\nignored"},
+		{5 * 1024 * 1024, "\r\n\r\n413 Content Too Large"}, // over MaxBodySize, rejected before the handler
+	}
+	for _, test := range tests {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		go sendRequest(conn, map[string]string{
+			"REQUEST_URI":    "/ignore",
+			"REQUEST_METHOD": "POST",
+			"CONTENT_TYPE":   "application/octet-stream",
+		}, strings.Repeat("x", test.size))
+		resp, err := io.ReadAll(conn)
+		if err != nil || !strings.HasSuffix(string(resp), test.expected) {
+			t.Errorf("%d bytes: expected a response ending with %q & EOF, got %q, %v", test.size, test.expected, resp, err)
 		}
-		println(string(buff[:readCnt]))
 	}
 }
 
-// http://www.python.ca/scgi/protocol.txt
-func sendRequest(conn net.Conn, header map[string]string, content string) {
-	if len(content) > 0 {
-		header["CONTENT_LENGTH"] = strconv.Itoa(len(content))
+func Test_MultipartParts(t *testing.T) {
+	var content bytes.Buffer
+	writer := multipart.NewWriter(&content)
+	writer.WriteField("sometext", "some value")
+	file, _ := writer.CreateFormFile("somefile", "file.txt")
+	file.Write([]byte("file content"))
+	writer.Close()
+
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	go sendRequest(client, map[string]string{
+		"REQUEST_URI":    "/upload",
+		"REQUEST_METHOD": "POST",
+		"CONTENT_TYPE":   writer.FormDataContentType(),
+	}, content.String())
+	req, err := readRequest(server, NewSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received []string
+	err = req.parseContent(func(req *Request, part *multipart.Part) error {
+		data, err := io.ReadAll(part)
+		received = append(received, part.FormName()+"|"+part.FileName()+"|"+string(data))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"sometext||some value", "somefile|file.txt|file content"}
+	if strings.Join(received, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected parts %q, got %q", expected, received)
+	}
+	if req.MultipartForm != nil {
+		t.Error("the parts should not be buffered in req.MultipartForm")
 	}
+}
 
-	headerSize := 0
-	for k, v := range header {
-		headerSize += len(k) + 1 // include the separator byte
-		headerSize += len(v) + 1
+func Test_ContextCancelOnDisconnect(t *testing.T) {
+	cancelled := make(chan error, 1)
+	srv := NewServer(NewSettings())
+	srv.AddHandler("/slow", func(req *Request) *Response {
+		select {
+		case <-req.Context().Done():
+			cancelled <- req.Context().Err()
+		case <-time.After(2 * time.Second):
+			cancelled <- nil
+		}
+		return NewResponse(RespCodeOK, RespTypeText, nil)
+	})
+
+	server, client := net.Pipe()
+	srv.trackConn(server, true)
+	go srv.handleConn(context.Background(), server)
+	sendRequest(client, map[string]string{"REQUEST_URI": "/slow", "REQUEST_METHOD": "GET"}, "")
+	client.Close() // the peer goes away before the response
+	if err := <-cancelled; err != context.Canceled {
+		t.Errorf("expected the request context to be cancelled, got %v", err)
 	}
-	headerSizeStr := strconv.Itoa(headerSize)
+	srv.Shutdown(context.Background())
+}
 
-	log.Println("sendRequest:")
-	conn.Write([]byte(headerSizeStr))
-	log.Println("sent headerSize:", headerSizeStr)
-	fmt.Fprint(conn, ":")
+func Test_ContextCancelOnHalfClose(t *testing.T) {
+	srv := NewServer(NewSettings())
+	srv.AddHandler("/slow", func(req *Request) *Response {
+		select {
+		case <-req.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+		return NewResponse(RespCodeOK, RespTypeText, []byte(fmt.Sprint(req.Context().Err())))
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
 
-	zero := []byte{0}
-	for k, v := range header {
-		fmt.Fprint(conn, k)
-		conn.Write(zero)
-		fmt.Fprint(conn, v)
-		conn.Write(zero)
-		log.Println("sent ", k, " = ", v)
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	sendRequest(conn, map[string]string{"REQUEST_URI": "/slow", "REQUEST_METHOD": "GET"}, "")
+	conn.(*net.TCPConn).CloseWrite()
+	// the half-closed connection is taken for a closed one, but the response is still sent
+	resp, _ := io.ReadAll(conn)
+	if !strings.HasSuffix(string(resp), "\r\n\r\ncontext canceled") {
+		t.Errorf("unexpected response %q", resp)
 	}
+}
 
-	fmt.Fprint(conn, ",")
-	fmt.Fprint(conn, content)
-	log.Println("sent content:", content)
+func Test_Shutdown(t *testing.T) {
+	srv := NewServer(NewSettings())
+	started := make(chan bool)
+	srv.AddHandler("/fast", func(req *Request) *Response {
+		started <- true
+		time.Sleep(100 * time.Millisecond)
+		return NewResponse(RespCodeOK, RespTypeText, []byte("fast"))
+	})
+	srv.AddHandler("/stuck", func(req *Request) *Response {
+		started <- true
+		<-req.Context().Done()
+		return nil
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error)
+	go func() { served <- srv.Serve(listener) }()
+
+	responses := make(chan string, 2)
+	for _, path := range []string{"/fast", "/stuck"} {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		sendRequest(conn, map[string]string{"REQUEST_URI": path, "REQUEST_METHOD": "GET"}, "")
+		<-started
+		go func() {
+			resp, _ := io.ReadAll(conn)
+			responses <- string(resp)
+		}()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	err = srv.Shutdown(ctx)
+	if err == nil || !strings.Contains(err.Error(), "closed 1 connection(s): GET /stuck") {
+		t.Errorf("unexpected Shutdown error: %v", err)
+	}
+	if err = <-served; err != ServerClosedErr {
+		t.Errorf("expected ServerClosedErr, got %v", err)
+	}
+	if resp1, resp2 := <-responses, <-responses; !strings.HasSuffix(resp1+resp2, "fast") {
+		t.Errorf("expected the /fast request to finish, got %q and %q", resp1, resp2)
+	}
+	if _, err = net.Dial("tcp", listener.Addr().String()); err == nil {
+		t.Error("the listener should be closed")
+	}
+}
+
+func Test_ServeMultipleListeners(t *testing.T) {
+	srv := NewServer(NewSettings())
+	srv.AddHandler("/", func(req *Request) *Response {
+		return NewResponse(RespCodeOK, RespTypeText, []byte("hello"))
+	})
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unixListener, err := net.Listen("unix", t.TempDir()+"/goscgi.socket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 2)
+	for _, listener := range []net.Listener{tcpListener, unixListener} {
+		go func(listener net.Listener) { served <- srv.Serve(listener) }(listener)
+	}
+
+	for _, listener := range []net.Listener{tcpListener, unixListener} {
+		addr := listener.Addr()
+		conn, err := net.Dial(addr.Network(), addr.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		sendRequest(conn, map[string]string{"REQUEST_URI": "/", "REQUEST_METHOD": "GET"}, "")
+		resp, _ := io.ReadAll(conn)
+		conn.Close()
+		if !strings.HasSuffix(string(resp), "\r\n\r\nhello") {
+			t.Errorf("%s: unexpected response %q", addr.Network(), resp)
+		}
+	}
+
+	if err = srv.Shutdown(context.Background()); err != nil {
+		t.Error(err)
+	}
+	for idx := 0; idx < 2; idx++ {
+		if err = <-served; err != ServerClosedErr {
+			t.Errorf("expected ServerClosedErr, got %v", err)
+		}
+	}
+	if err = srv.Serve(tcpListener); err != ServerClosedErr {
+		t.Errorf("Serve after Shutdown: expected ServerClosedErr, got %v", err)
+	}
+}
+
+func Test_DeprecatedClose(t *testing.T) {
+	settings := NewSettings()
+	settings.ShutdownTimeout = 0 // the default, the running requests are not cut
+	srv := NewServer(settings)
+	started := make(chan bool, 1)
+	srv.AddHandler("/", func(req *Request) *Response {
+		return NewResponse(RespCodeOK, RespTypeText, []byte("hello"))
+	})
+	srv.AddHandler("/slow", func(req *Request) *Response {
+		started <- true
+		time.Sleep(100 * time.Millisecond)
+		return NewResponse(RespCodeOK, RespTypeText, []byte("slow hello"))
+	})
+	socket := t.TempDir() + "/goscgi.socket"
+	served := make(chan error)
+	go func() { served <- srv.ListenUnix(socket) }()
+	for idx := 0; idx < 100; idx++ {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			conn.Close()
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	sendRequest(conn, map[string]string{"REQUEST_URI": "/slow", "REQUEST_METHOD": "GET"}, "")
+	<-started
+	srv.Close <- true
+	if resp, _ := io.ReadAll(conn); !strings.HasSuffix(string(resp), "\r\n\r\nslow hello") {
+		t.Errorf("expected the /slow request to finish, got %q", resp)
+	}
+	if err := <-served; err != ServerClosedErr {
+		t.Errorf("expected ServerClosedErr, got %v", err)
+	}
+	srv.WaitGroup.Wait()
 }
@@ -18,7 +18,31 @@ var (
 	UnexpectedEndErr  = errors.New("Unexpected end of stream")
 )
 
+// violations of the SCGI protocol rejected by Settings.StrictProtocol, wrapped in a *ProtocolError of kind MalformedRequest
+var (
+	MalformedNetstringErr   = errors.New("Malformed netstring")      // length with leading zeros or a sign, missing ','
+	MalformedContentSizeErr = errors.New("Malformed CONTENT_LENGTH") // not a decimal number, leading zeros or a sign
+	ContentSizeNotFirstErr  = errors.New("CONTENT_LENGTH is not the first header")
+	MissingScgiErr          = errors.New("Missing SCGI=1 header")
+	DuplicateHeaderErr      = errors.New("Duplicate header")
+)
+
+// isDecimal reports if str is a netstring length: digits, without leading zeros.
+func isDecimal(str string) bool {
+	if len(str) == 0 || (str[0] == '0' && len(str) > 1) {
+		return false
+	}
+	for idx := 0; idx < len(str); idx++ {
+		if str[idx] < '0' || str[idx] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
 // http://www.python.ca/scgi/protocol.txt
+// The errors are returned as *ProtocolError; with settings.StrictProtocol,
+// the violations of the protocol are errors too.
 func ReadHeader(conn net.Conn, settings *Settings) (http.Header, error) {
 	var err error
 	const buffSize = 8 // first we read only 8 bytes from which we determine the headerSize
@@ -27,10 +51,11 @@ func ReadHeader(conn net.Conn, settings *Settings) (http.Header, error) {
 	for alreadyRead < buffSize {
 		conn.SetReadDeadline(time.Now().Add(settings.ReadTimeout))
 		if readCnt, err = conn.Read(buff[alreadyRead:]); err != nil {
-			return nil, err
+			return nil, newConnError(err, alreadyRead)
 		}
 		alreadyRead += readCnt
 	}
+	readCnt = alreadyRead // the 8 bytes may arrive in several reads
 	var idx int
 	var headerSize int
 	var headerSizeStr string
@@ -42,17 +67,26 @@ func ReadHeader(conn net.Conn, settings *Settings) (http.Header, error) {
 		}
 	}
 	if len(headerSizeStr) == 0 {
-		return nil, InvalidHeaderErr
+		if isDecimal(string(buff[:])) {
+			return nil, &ProtocolError{Kind: HeaderTooLarge, Offset: 0, Err: InvalidHeaderErr}
+		}
+		return nil, &ProtocolError{Offset: 0, Err: InvalidHeaderErr}
+	}
+	if settings.StrictProtocol && !isDecimal(headerSizeStr) {
+		return nil, &ProtocolError{Offset: 0, Err: MalformedNetstringErr}
 	}
 
 	if int64hs, err := strconv.ParseInt(headerSizeStr, 10, 0); err != nil {
-		return nil, err
+		return nil, &ProtocolError{Offset: 0, Err: err}
 	} else {
 		headerSize = int(int64hs)
 	}
-	if headerSize <= 0 || headerSize > settings.MaxHeaderSize {
-		return nil, InvalidHeaderErr
+	if headerSize <= 0 {
+		return nil, &ProtocolError{Offset: 0, Err: InvalidHeaderErr}
+	} else if headerSize > settings.MaxHeaderSize {
+		return nil, &ProtocolError{Kind: HeaderTooLarge, Offset: 0, Err: InvalidHeaderErr}
 	}
+	offset := len(headerSizeStr) + 1 // the offset of headerBuff in the request
 
 	headerSize++ // add the final ','
 	headerBuff := make([]byte, headerSize)
@@ -64,20 +98,27 @@ func ReadHeader(conn net.Conn, settings *Settings) (http.Header, error) {
 	for alreadyRead < headerSize {
 		conn.SetReadDeadline(time.Now().Add(settings.ReadTimeout))
 		if readCnt, err = conn.Read(headerBuff[alreadyRead:]); err != nil {
-			return nil, err
+			return nil, newConnError(err, offset+alreadyRead)
 		}
 		alreadyRead += readCnt
 	}
+	if settings.StrictProtocol && headerBuff[headerSize-1] != ',' {
+		return nil, &ProtocolError{Offset: offset + headerSize - 1, Err: MalformedNetstringErr}
+	}
 
 	header := http.Header{}
 	var name string
 	nameExpected := true
+	nameCnt := 0
 	idx = 0
 	for {
 		if idx >= headerSize {
-			return nil, UnexpectedEndErr
+			return nil, &ProtocolError{Offset: offset + idx, Err: UnexpectedEndErr}
 		}
 		if nameExpected && headerBuff[idx] == ',' {
+			if settings.StrictProtocol && idx != headerSize-1 {
+				return nil, &ProtocolError{Offset: offset + idx, Err: MalformedNetstringErr}
+			}
 			break // end of header reached
 		}
 		baseIdx := idx
@@ -85,16 +126,51 @@ func ReadHeader(conn net.Conn, settings *Settings) (http.Header, error) {
 			idx++
 		}
 		if idx >= headerSize {
-			return nil, UnexpectedEndErr
+			return nil, &ProtocolError{Offset: offset + idx, Err: UnexpectedEndErr}
 		}
 		if str := string(headerBuff[baseIdx:idx]); nameExpected {
 			name = str
 			nameExpected = false
+			if settings.StrictProtocol {
+				if err = checkName(header, name, nameCnt); err != nil {
+					return nil, &ProtocolError{Name: name, Offset: offset + baseIdx, Err: err}
+				}
+			}
+			nameCnt++
 		} else {
+			if settings.StrictProtocol {
+				if err = checkValue(name, str); err != nil {
+					return nil, &ProtocolError{Name: name, Offset: offset + baseIdx, Err: err}
+				}
+			}
 			header.Add(name, str)
 			nameExpected = true
 		}
 		idx++ // skip 0
 	}
+	if settings.StrictProtocol && header.Get(ScgiKey) != "1" {
+		return nil, &ProtocolError{Name: ScgiKey, Offset: offset + idx, Err: MissingScgiErr}
+	}
 	return header, nil
 }
+
+// checkName checks the position & uniqueness of the header name, the nameIdx-th one.
+func checkName(header http.Header, name string, nameIdx int) error {
+	if (nameIdx == 0) != (name == ContentSizeKey) {
+		return ContentSizeNotFirstErr
+	}
+	if _, ok := header[http.CanonicalHeaderKey(name)]; ok {
+		return DuplicateHeaderErr
+	}
+	return nil
+}
+
+func checkValue(name, value string) error {
+	if name == ContentSizeKey && !isDecimal(value) {
+		return MalformedContentSizeErr
+	}
+	if name == ScgiKey && value != "1" {
+		return MissingScgiErr
+	}
+	return nil
+}
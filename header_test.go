@@ -0,0 +1,60 @@
+// Copyright 2013 Liviu G. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package goscgi
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+)
+
+// netstring encodes the header fields as an SCGI header.
+func netstring(fields string) string {
+	return strconv.Itoa(len(fields)) + ":" + fields + ","
+}
+
+func readRawHeader(raw string, strict bool) error {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	go client.Write([]byte(raw + "padding."))
+	settings := NewSettings()
+	settings.StrictProtocol = strict
+	_, err := ReadHeader(server, settings)
+	return err
+}
+
+func Test_StrictHeader(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected error
+		offset   int
+	}{
+		{netstring("CONTENT_LENGTH\x000\x00SCGI\x001\x00REQUEST_METHOD\x00GET\x00"), nil, 0},
+		{"0" + netstring("CONTENT_LENGTH\x000\x00SCGI\x001\x00"), MalformedNetstringErr, 0},
+		{"+" + netstring("CONTENT_LENGTH\x000\x00SCGI\x001\x00"), MalformedNetstringErr, 0},
+		{netstring("SCGI\x001\x00CONTENT_LENGTH\x000\x00"), ContentSizeNotFirstErr, 3},
+		{netstring("CONTENT_LENGTH\x00007\x00SCGI\x001\x00"), MalformedContentSizeErr, 18},
+		{netstring("CONTENT_LENGTH\x00+7\x00SCGI\x001\x00"), MalformedContentSizeErr, 18},
+		{netstring("CONTENT_LENGTH\x000\x00REQUEST_METHOD\x00GET\x00"), MissingScgiErr, 39},
+		{netstring("CONTENT_LENGTH\x000\x00SCGI\x002\x00"), MissingScgiErr, 25},
+		{netstring("CONTENT_LENGTH\x000\x00SCGI\x001\x00SCGI\x001\x00"), DuplicateHeaderErr, 27},
+		{"26:CONTENT_LENGTH\x000\x00SCGI\x001\x00,x,", MalformedNetstringErr, 27},
+		{"24:CONTENT_LENGTH\x000\x00SCGI\x001\x00;", MalformedNetstringErr, 27},
+	}
+	for _, test := range tests {
+		err := readRawHeader(test.raw, true)
+		var protocolErr *ProtocolError
+		if !errors.Is(err, test.expected) || (err != nil && (!errors.As(err, &protocolErr) || protocolErr.Offset != test.offset)) {
+			t.Errorf("%q: expected %v at %d, got %v", test.raw, test.expected, test.offset, err)
+		}
+		if test.expected != nil && test.expected != MalformedNetstringErr {
+			if err = readRawHeader(test.raw, false); err != nil {
+				t.Errorf("%q: lenient mode failed with %v", test.raw, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,306 @@
+// Copyright 2013 Liviu G. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package goscgi
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/cgi"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Serve accepts incoming SCGI connections on the listener l, creating a new
+// goroutine for each. The goroutine reads the request and then calls h to
+// reply to it. If h is nil, http.DefaultServeMux is used.
+// It works like net/http/fcgi.Serve, but for the SCGI protocol.
+func Serve(l net.Listener, h http.Handler) error {
+	return (&HTTPServer{Handler: h}).Serve(l)
+}
+
+// HTTPServer serves an http.Handler over SCGI, like Serve, with optional settings
+// and a panic hook.
+type HTTPServer struct {
+	Handler  http.Handler // nil = http.DefaultServeMux
+	Settings *Settings    // nil = NewSettings()
+	// PanicHandler, if not nil, is called with the value & stack trace of the panics
+	// recovered while handling req, after they are logged; see Server.PanicHandler.
+	PanicHandler func(req *http.Request, value any, stack []byte)
+}
+
+// Serve accepts incoming SCGI connections on l, creating a new goroutine for each.
+// It returns the error of l.Accept.
+func (srv *HTTPServer) Serve(l net.Listener) error {
+	h := srv.Handler
+	if h == nil {
+		h = http.DefaultServeMux
+	}
+	settings := srv.Settings
+	if settings == nil {
+		settings = NewSettings()
+	}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go srv.serveHTTP(conn, settings, h)
+	}
+}
+
+func (srv *HTTPServer) serveHTTP(conn net.Conn, settings *Settings, h http.Handler) {
+	defer conn.Close()
+	req, err := readHTTPRequest(conn, settings)
+	if err != nil {
+		if resp := errorResponse(err, ""); resp != nil {
+			log.Println("HTTPServer.serveHTTP, readHTTPRequest:", err.Error())
+			if err = resp.Write(conn, settings.WriteTimeout); err != nil {
+				log.Println("HTTPServer.serveHTTP, errorResponse.Write:", err.Error())
+			}
+		}
+		return
+	}
+	resp := &httpResponse{out: connWriter{conn, settings.WriteTimeout}, header: http.Header{}, head: req.Method == http.MethodHead}
+	defer func() {
+		if value := recover(); value != nil {
+			if value == http.ErrAbortHandler {
+				return // abort the response, the connection is closed
+			}
+			stack := debug.Stack()
+			log.Printf("HTTPServer.serveHTTP, panic: %v\n%s %s\n%s", value, req.Method, req.RequestURI, stack)
+			if srv.PanicHandler != nil {
+				callPanicHandler("HTTPServer.PanicHandler", func() { srv.PanicHandler(req, value, stack) })
+			}
+			if !resp.sentHeader {
+				resp.header = http.Header{}
+				resp.buff = nil
+				resp.wroteHeader = false
+				http.Error(resp, string(RespCodeInternalError), http.StatusInternalServerError)
+				resp.finish()
+			}
+		}
+	}()
+	h.ServeHTTP(resp, req)
+	if err = resp.finish(); err != nil {
+		log.Println("HTTPServer.serveHTTP, finish:", err.Error())
+	}
+}
+
+// readHTTPRequest reads the SCGI header from conn and converts
+// the CGI variables into an *http.Request whose Body reads the content
+// directly from the connection.
+func readHTTPRequest(conn net.Conn, settings *Settings) (*http.Request, error) {
+	header, err := ReadHeader(conn, settings)
+	if err != nil {
+		return nil, err
+	}
+	// ReadHeader canonicalizes the names (REQUEST_URI -> Request_uri),
+	// CGI variable names are always upper case so we just revert that
+	params := make(map[string]string, len(header))
+	for name, values := range header {
+		if len(values) > 0 {
+			params[strings.ToUpper(name)] = values[0]
+		}
+	}
+	if len(params["SERVER_PROTOCOL"]) == 0 {
+		params["SERVER_PROTOCOL"] = "HTTP/1.0"
+	}
+	var contentSize int64
+	if contentSizeStr := params[ContentSizeKey]; len(contentSizeStr) > 0 {
+		if contentSize, err = strconv.ParseInt(contentSizeStr, 10, 64); err != nil {
+			return nil, newHeaderError(MalformedRequest, ContentSizeKey, err)
+		}
+		if contentSize < 0 {
+			return nil, newHeaderError(MalformedRequest, ContentSizeKey, InvalidContentErr)
+		} else if settings.MaxBodySize > 0 && contentSize > settings.MaxBodySize {
+			return nil, newHeaderError(ContentTooLarge, ContentSizeKey, InvalidContentErr)
+		}
+	}
+	req, err := cgi.RequestFromMap(params)
+	if err != nil {
+		return nil, &ProtocolError{Offset: -1, Err: err}
+	}
+	req.Body = io.NopCloser(newContentReader(conn, contentSize, settings))
+	return req, nil
+}
+
+// contentReader reads at most remaining bytes from conn,
+// refreshing the read deadline before every read.
+// The content size is checked against Settings.MaxBodySize before it's created.
+type contentReader struct {
+	conn      net.Conn
+	remaining int64
+	timeout   time.Duration
+	onEnd     func() // if not nil, called when all the content was read
+	err       error  // the last error returned by conn
+}
+
+func newContentReader(conn net.Conn, contentSize int64, settings *Settings) *contentReader {
+	return &contentReader{conn: conn, remaining: contentSize, timeout: settings.ReadTimeout}
+}
+
+func (cr *contentReader) Read(buff []byte) (int, error) {
+	if cr.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(buff)) > cr.remaining {
+		buff = buff[:cr.remaining]
+	}
+	cr.conn.SetReadDeadline(time.Now().Add(cr.timeout))
+	readCnt, err := cr.conn.Read(buff)
+	cr.remaining -= int64(readCnt)
+	if err == io.EOF && cr.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		cr.err = err
+	}
+	if cr.remaining == 0 && cr.onEnd != nil {
+		cr.onEnd()
+		cr.onEnd = nil
+	}
+	return readCnt, err
+}
+
+// the limits of drainContent: a larger content is left unread, as is the rest of a slow one
+const (
+	maxDrainSize = 16 * 1024 * 1024
+	maxDrainTime = 5 * time.Second
+)
+
+// drainContent reads & discards the content left unread by the handler (or by an error response):
+// closing a connection with unread data makes the kernel reset it, and the peer may lose the response.
+func drainContent(body io.Reader) {
+	cr, ok := body.(*contentReader)
+	if !ok || cr.remaining <= 0 || cr.remaining > maxDrainSize || cr.err != nil {
+		return
+	}
+	cr.onEnd = nil // the peer isn't watched anymore
+	deadline := time.Now().Add(maxDrainTime)
+	var buff [32 * 1024]byte
+	for time.Now().Before(deadline) {
+		if _, err := cr.Read(buff[:]); err != nil {
+			return
+		}
+	}
+}
+
+// the max amount of content buffered before the headers are sent;
+// if the handler writes less than this, Content-Length is set automatically
+const httpResponseBuffSize = 4096
+
+// httpResponse implements http.ResponseWriter and http.Flusher.
+// The response is sent in the same CGI format used by Response.Write:
+// a "Status:" line, followed by the headers, an empty line and the content.
+type httpResponse struct {
+	out         connWriter
+	header      http.Header
+	buff        []byte
+	status      int
+	wroteHeader bool // WriteHeader was called
+	sentHeader  bool // the headers were written to conn
+	head        bool // HEAD request: the content is counted but not sent
+	err         error
+}
+
+func (resp *httpResponse) Header() http.Header {
+	return resp.header
+}
+
+func (resp *httpResponse) WriteHeader(code int) {
+	if resp.wroteHeader {
+		return
+	}
+	resp.wroteHeader = true
+	resp.status = code
+}
+
+func (resp *httpResponse) Write(data []byte) (int, error) {
+	if !resp.wroteHeader {
+		resp.WriteHeader(http.StatusOK)
+	}
+	if resp.err != nil {
+		return 0, resp.err
+	}
+	if !bodyAllowed(resp.status) {
+		return 0, http.ErrBodyNotAllowed
+	}
+	if !resp.sentHeader {
+		if len(resp.header.Get("Content-Type")) == 0 {
+			resp.header.Set("Content-Type", http.DetectContentType(data))
+		}
+		if len(resp.buff)+len(data) <= httpResponseBuffSize {
+			resp.buff = append(resp.buff, data...)
+			return len(data), nil
+		}
+		if resp.sendHeader(); resp.err != nil {
+			return 0, resp.err
+		}
+	}
+	if resp.head {
+		return len(data), nil
+	}
+	return resp.write(data)
+}
+
+func (resp *httpResponse) Flush() {
+	if !resp.wroteHeader {
+		resp.WriteHeader(http.StatusOK)
+	}
+	if !resp.sentHeader {
+		resp.sendHeader()
+	}
+}
+
+// finish sends whatever is still buffered; the connection close
+// marks the end of the response.
+func (resp *httpResponse) finish() error {
+	if !resp.wroteHeader {
+		resp.WriteHeader(http.StatusOK)
+	}
+	if !resp.sentHeader {
+		if len(resp.header.Get("Content-Length")) == 0 && bodyAllowed(resp.status) {
+			resp.header.Set("Content-Length", strconv.Itoa(len(resp.buff)))
+		}
+		resp.sendHeader()
+	}
+	return resp.err
+}
+
+func (resp *httpResponse) sendHeader() {
+	resp.sentHeader = true
+	var buff bytes.Buffer
+	buff.Write(status)
+	buff.WriteString(strconv.Itoa(resp.status) + " " + ReasonPhrase(resp.status))
+	buff.Write(crlf)
+	resp.header.Write(&buff)
+	buff.Write(crlf)
+	if !resp.head {
+		buff.Write(resp.buff)
+	}
+	resp.buff = nil
+	resp.write(buff.Bytes())
+}
+
+func (resp *httpResponse) write(data []byte) (int, error) {
+	writeCnt, err := resp.out.Write(data)
+	if err != nil {
+		resp.err = err
+	}
+	return writeCnt, err
+}
+
+// bodyAllowed reports whether a response with the given status may have a body (RFC 7230, section 3.3).
+func bodyAllowed(status int) bool {
+	if (status >= 100 && status <= 199) || status == http.StatusNoContent || status == http.StatusNotModified {
+		return false
+	}
+	return true
+}
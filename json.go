@@ -0,0 +1,124 @@
+// Copyright 2013 Liviu G. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package goscgi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+var (
+	NotJSONErr      = errors.New("The content type is not JSON")
+	EmptyContentErr = errors.New("Empty content")
+	TrailingDataErr = errors.New("Data after the JSON value")
+)
+
+// JSONError describes why Request.DecodeJSON failed; Response turns it into a reply.
+type JSONError struct {
+	Status int    // 400, 413 or 415
+	Field  string // the offending field, if known
+	Offset int64  // the offset in the content where decoding failed; -1 = unknown
+	Err    error
+}
+
+func (err *JSONError) Error() string {
+	return err.Err.Error()
+}
+
+func (err *JSONError) Unwrap() error {
+	return err.Err
+}
+
+func (err *JSONError) StatusCode() int {
+	return err.Status
+}
+
+// Response returns a JSON reply describing the error, e.g.
+//
+//	{"status":400,"error":"json: cannot unmarshal string into Go struct field User.age of type int","field":"age","offset":27}
+func (err *JSONError) Response() *Response {
+	body := struct {
+		Status int    `json:"status"`
+		Error  string `json:"error"`
+		Field  string `json:"field,omitempty"`
+		Offset *int64 `json:"offset,omitempty"`
+	}{Status: err.Status, Error: err.Err.Error(), Field: err.Field}
+	if err.Offset >= 0 {
+		body.Offset = &err.Offset
+	}
+	content, _ := json.Marshal(body)
+	return NewStatusResponse(err.Status, RespTypeJson, content)
+}
+
+// isJSON reports if contentType is application/json or a +json type (RFC 6839).
+func isJSON(contentType string) bool {
+	return contentType == ContentTypeJSON || (strings.HasPrefix(contentType, "application/") && strings.HasSuffix(contentType, "+json"))
+}
+
+// DecodeJSON decodes the request content, a single JSON value, into v.
+// The content type must be JSON, the content at most Settings.MaxContentSize bytes
+// and the JSON objects may not have fields unknown to v.
+// The errors are returned as *JSONError.
+func (req *Request) DecodeJSON(v any) error {
+	if !isJSON(req.ContentType) {
+		return &JSONError{Status: http.StatusUnsupportedMediaType, Offset: -1, Err: NotJSONErr}
+	}
+	if req.ContentSize == 0 {
+		return &JSONError{Status: http.StatusBadRequest, Offset: -1, Err: EmptyContentErr}
+	}
+	if req.ContentSize > req.Settings.MaxContentSize {
+		return &JSONError{Status: http.StatusRequestEntityTooLarge, Offset: -1, Err: InvalidContentErr}
+	}
+	var content io.Reader = req.Body
+	if req.Content != nil {
+		content = bytes.NewReader(req.Content)
+	}
+	decoder := json.NewDecoder(content)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return newJSONError(err)
+	}
+	// More would miss a trailing '}' or ']': only the end of the content is accepted
+	decoder.More() // skips the white space, InputOffset is then the start of the trailing data
+	offset := decoder.InputOffset()
+	if _, err := decoder.Token(); err != io.EOF {
+		return &JSONError{Status: http.StatusBadRequest, Offset: offset, Err: TrailingDataErr}
+	}
+	return nil
+}
+
+func newJSONError(err error) *JSONError {
+	jsonErr := &JSONError{Status: http.StatusBadRequest, Offset: -1, Err: err}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		jsonErr.Offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		jsonErr.Field = typeErr.Field
+		jsonErr.Offset = typeErr.Offset
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// DisallowUnknownFields has no error type, only this message (checked by Test_JSON)
+		jsonErr.Field = strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+	case err == io.EOF:
+		jsonErr.Err = EmptyContentErr
+	}
+	return jsonErr
+}
+
+// NewJSONResponse creates a response with the status code & v encoded as JSON.
+// v is encoded while it's sent, so an encoding error (e.g. a channel in v) can only
+// be reported by closing the connection: the status and headers were already sent.
+func NewJSONResponse(code int, v any, cookies ...*http.Cookie) *Response {
+	resp := NewStatusResponse(code, RespTypeJson, nil, cookies...)
+	resp.Stream = func(w *ResponseWriter) error {
+		return json.NewEncoder(w).Encode(v)
+	}
+	return resp
+}
@@ -0,0 +1,58 @@
+// Copyright 2013 Liviu G. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package goscgi_test
+
+import (
+	"context"
+	"goscgi"
+	"goscgi/scgitest"
+	"net/http"
+	"testing"
+)
+
+func Test_JSON(t *testing.T) {
+	type user struct {
+		Name string `json:"name"`
+		Age  int    `json:"age"`
+	}
+	srv := goscgi.NewServer(goscgi.NewSettings())
+	srv.AddMethodHandler(goscgi.POST, "/users", func(req *goscgi.Request) *goscgi.Response {
+		var u user
+		if err := req.DecodeJSON(&u); err != nil {
+			return err.(*goscgi.JSONError).Response()
+		}
+		u.Age++
+		return goscgi.NewJSONResponse(http.StatusCreated, u)
+	})
+	listener := scgitest.Start(srv)
+	defer srv.Shutdown(context.Background())
+
+	tests := []struct {
+		contentType, content string
+		status               int
+		expected             string
+	}{
+		{"application/json", `{"name":"Arthur","age":42}`, http.StatusCreated, `{"name":"Arthur","age":43}` + "\n"},
+		{"application/json; charset=utf-8", `{"name":"Ford","age":"old"}`, http.StatusBadRequest,
+			`{"status":400,"error":"json: cannot unmarshal string into Go struct field user.age of type int","field":"age","offset":26}`},
+		{"application/json", `{"name":"Zaphod","heads":2}`, http.StatusBadRequest,
+			`{"status":400,"error":"json: unknown field \"heads\"","field":"heads"}`},
+		{"application/json", `{"name":`, http.StatusBadRequest, `{"status":400,"error":"unexpected EOF"}`},
+		{"application/json", `{} {}`, http.StatusBadRequest, `{"status":400,"error":"Data after the JSON value","offset":3}`},
+		{"application/json", `{"name":"Arthur","age":42}}`, http.StatusBadRequest, `{"status":400,"error":"Data after the JSON value","offset":26}`},
+		{"application/json", `{"name":"Arthur","age":42}` + "]", http.StatusBadRequest, `{"status":400,"error":"Data after the JSON value","offset":26}`},
+		{"application/json", `{"name":"Ford","age":1}` + " \n", http.StatusCreated, `{"name":"Ford","age":2}` + "\n"},
+		{"text/plain", `{}`, http.StatusUnsupportedMediaType, `{"status":415,"error":"The content type is not JSON"}`},
+	}
+	for _, test := range tests {
+		resp, body, err := listener.Fetch(scgitest.NewRequest("POST", "/users").Content(test.contentType, []byte(test.content)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != test.status || string(body) != test.expected || resp.Header.Get("Content-Type") != "application/json; charset=utf-8" {
+			t.Errorf("%s: unexpected response %d %s %q", test.content, resp.StatusCode, resp.Header.Get("Content-Type"), body)
+		}
+	}
+}
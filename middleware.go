@@ -0,0 +1,102 @@
+// Copyright 2013 Liviu G. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package goscgi
+
+import "strings"
+
+// Middleware wraps a HandlerFunc with code that runs before and/or after it,
+// or instead of it (e.g. returning an error response without calling next).
+//
+// The middleware stacks are applied in this order, the first one being the outermost:
+// server middleware (Server.Use), group middleware (outer groups first),
+// then the handler's own middleware (WithMiddleware).
+// Inside each stack, middleware run in the order they were added.
+// Server middleware also wrap the 404 & 405 responses.
+// The request content (forms, BufferContent, the parts passed to PartFunc) is read
+// by the innermost wrapper, right before the handler: middleware returning without
+// calling next (e.g. rejecting an unauthenticated upload) leave it unread.
+//
+// The chains are built when the handlers are added and rebuilt when middleware is added,
+// so a Middleware is called once per handler, not per request: the state it keeps
+// for the returned HandlerFunc (e.g. a rate limiter) is shared by the requests.
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// WithMiddleware adds middleware to a single handler.
+func WithMiddleware(middleware ...Middleware) HandlerOption {
+	return func(handler *Handler) {
+		handler.Middleware = append(handler.Middleware, middleware...)
+	}
+}
+
+// Group registers handlers under a common path prefix and middleware stack.
+type Group struct {
+	router     *Router
+	parent     *Group
+	prefix     string
+	middleware []Middleware
+}
+
+// Group creates a handler group for the paths under prefix (e.g. "/api").
+func (router *Router) Group(prefix string, middleware ...Middleware) *Group {
+	return &Group{router: router, prefix: strings.TrimSuffix(prefix, "/"), middleware: middleware}
+}
+
+// Group creates a nested group; its path prefix & middleware are added to the parent ones.
+func (group *Group) Group(prefix string, middleware ...Middleware) *Group {
+	child := group.router.Group(group.prefix+prefix, middleware...)
+	child.parent = group
+	return child
+}
+
+// Use adds middleware to all the handlers of the group, including the ones already added.
+func (group *Group) Use(middleware ...Middleware) {
+	group.middleware = append(group.middleware, middleware...)
+	group.router.rechain()
+}
+
+// AddHandler adds a handler for prefix + path, whatever the request method.
+func (group *Group) AddHandler(path string, handler HandlerFunc, options ...HandlerOption) {
+	group.router.add("", group.prefix+path, handler, group.options(options))
+}
+
+// AddMethodHandler adds a handler for prefix + path and method.
+func (group *Group) AddMethodHandler(method, path string, handler HandlerFunc, options ...HandlerOption) {
+	group.router.add(method, group.prefix+path, handler, group.options(options))
+}
+
+func (group *Group) options(options []HandlerOption) []HandlerOption {
+	return append(options, func(handler *Handler) {
+		handler.group = group
+	})
+}
+
+// use adds server middleware (see Server.Use).
+func (router *Router) use(middleware []Middleware) {
+	router.middleware = append(router.middleware, middleware...)
+	router.rechain()
+}
+
+// rechain rebuilds the middleware chains of all the handlers, after a middleware stack changed.
+func (router *Router) rechain() {
+	for _, handler := range router.handlers {
+		handler.chained = router.chain(handler)
+	}
+}
+
+// chain wraps the handler func with all the middleware that applies to it.
+func (router *Router) chain(handler *Handler) HandlerFunc {
+	handlerFunc := wrap(withContent(handler), handler.Middleware)
+	for group := handler.group; group != nil; group = group.parent {
+		handlerFunc = wrap(handlerFunc, group.middleware)
+	}
+	return wrap(handlerFunc, router.middleware)
+}
+
+func wrap(handlerFunc HandlerFunc, middleware []Middleware) HandlerFunc {
+	for idx := len(middleware) - 1; idx >= 0; idx-- {
+		handlerFunc = middleware[idx](handlerFunc)
+	}
+	return handlerFunc
+}
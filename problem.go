@@ -0,0 +1,164 @@
+// Copyright 2013 Liviu G. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package goscgi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Problem is an error described as RFC 7807 problem details.
+// Handlers can return problem.Response(), return the problem as error (see HandlerE)
+// or panic with it; the returned & raised problems are answered by Server.ErrorHandler.
+type Problem struct {
+	Type       string         // a URI identifying the problem type; "about:blank" = described by the status only
+	Title      string         // a short summary of the problem type
+	Status     int            // the HTTP status code
+	Detail     string         // an explanation specific to this occurrence
+	Instance   string         // a URI identifying this occurrence
+	Extensions map[string]any // additional members, e.g. "balance": 30
+}
+
+// NewProblem creates a problem described by the status, titled with its reason phrase.
+func NewProblem(status int, detail string) *Problem {
+	return &Problem{Type: "about:blank", Title: ReasonPhrase(status), Status: status, Detail: detail}
+}
+
+func (problem *Problem) Error() string {
+	if len(problem.Detail) > 0 {
+		return problem.Title + ": " + problem.Detail
+	}
+	return problem.Title
+}
+
+func (problem *Problem) StatusCode() int {
+	return problem.Status
+}
+
+// MarshalJSON encodes the problem members followed by the extension members;
+// the extensions named like a problem member are left out.
+func (problem *Problem) MarshalJSON() ([]byte, error) {
+	content, err := json.Marshal(struct {
+		Type     string `json:"type,omitempty"`
+		Title    string `json:"title,omitempty"`
+		Status   int    `json:"status,omitempty"`
+		Detail   string `json:"detail,omitempty"`
+		Instance string `json:"instance,omitempty"`
+	}{problem.Type, problem.Title, problem.Status, problem.Detail, problem.Instance})
+	if err != nil || len(problem.Extensions) == 0 {
+		return content, err
+	}
+	extensions := make(map[string]any, len(problem.Extensions))
+	for name, value := range problem.Extensions {
+		switch name {
+		case "type", "title", "status", "detail", "instance":
+		default:
+			extensions[name] = value
+		}
+	}
+	extContent, err := json.Marshal(extensions)
+	if err != nil || len(extensions) == 0 {
+		return content, err
+	}
+	if len(content) == 2 { // {}
+		return extContent, nil
+	}
+	content = append(content[:len(content)-1], ',')
+	return append(content, extContent[1:]...), nil
+}
+
+// Response returns the problem as an application/problem+json response;
+// an invalid Status is sent as 500.
+func (problem *Problem) Response() *Response {
+	status := problem.Status
+	if !ValidStatus(status) {
+		status = http.StatusInternalServerError
+	}
+	content, err := json.Marshal(problem)
+	if err != nil {
+		content, _ = json.Marshal(NewProblem(status, problem.Detail))
+	}
+	return NewStatusResponse(status, RespTypeProblem, content)
+}
+
+// newErrorResponse returns the response for the errors detected by the server:
+// problem details if the Accept header prefers them, plain text otherwise.
+func newErrorResponse(status int, detail string, accept string) *Response {
+	if prefersProblem(accept) {
+		return NewProblem(status, detail).Response()
+	}
+	return NewStatusResponse(status, RespTypeText, RespCode(status))
+}
+
+// prefersProblem reports if the Accept header rates a JSON type above text.
+func prefersProblem(accept string) bool {
+	if len(accept) == 0 {
+		return false
+	}
+	switch Negotiate(accept, "text/plain", string(RespTypeProblem), "application/json") {
+	case string(RespTypeProblem), "application/json":
+		return true
+	}
+	return false
+}
+
+// Negotiate returns the offer preferred by the Accept header (RFC 9110, section 12.5.1),
+// the first offer if accept is empty, "" if none is acceptable.
+// The offers are media types without parameters; among equally rated offers, the first wins.
+func Negotiate(accept string, offers ...string) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	if len(strings.TrimSpace(accept)) == 0 {
+		return offers[0]
+	}
+	type rating struct {
+		quality     float64
+		specificity int // 0 = */*, 1 = type/*, 2 = type/subtype
+	}
+	ratings := make([]rating, len(offers))
+	for idx := range ratings {
+		ratings[idx].specificity = -1
+	}
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(mediaRange, ";")
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+		quality := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if name, value, ok := strings.Cut(param, "="); ok && strings.TrimSpace(name) == "q" {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && q >= 0 && q <= 1 {
+					quality = q
+				}
+			}
+		}
+		for idx, offer := range offers {
+			specificity := -1
+			switch {
+			case mediaType == offer:
+				specificity = 2
+			case strings.HasSuffix(mediaType, "/*") && strings.HasPrefix(offer, mediaType[:len(mediaType)-1]):
+				specificity = 1
+			case mediaType == "*/*":
+				specificity = 0
+			}
+			// the most specific range matching an offer gives its quality
+			if specificity > ratings[idx].specificity {
+				ratings[idx] = rating{quality, specificity}
+			}
+		}
+	}
+	best := -1
+	for idx, rating := range ratings {
+		if rating.specificity >= 0 && rating.quality > 0 && (best < 0 || rating.quality > ratings[best].quality) {
+			best = idx
+		}
+	}
+	if best < 0 {
+		return ""
+	}
+	return offers[best]
+}
@@ -0,0 +1,60 @@
+// Copyright 2013 Liviu G. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package goscgi_test
+
+import (
+	"context"
+	"goscgi"
+	"goscgi/scgitest"
+	"net/http"
+	"testing"
+)
+
+func Test_Problem(t *testing.T) {
+	srv := goscgi.NewServer(goscgi.NewSettings())
+	srv.AddMethodHandler(goscgi.POST, "/transfer", func(req *goscgi.Request) *goscgi.Response {
+		problem := goscgi.NewProblem(http.StatusForbidden, "Your balance is 30, but that costs 50.")
+		problem.Type = "https://example.com/probs/out-of-credit"
+		problem.Title = "You do not have enough credit."
+		problem.Instance = "/account/12345/msgs/abc"
+		problem.Extensions = map[string]any{"balance": 30, "accounts": []string{"/account/12345"}, "status": 200}
+		return problem.Response()
+	})
+	srv.AddHandler("/panic", func(req *goscgi.Request) *goscgi.Response {
+		panic(goscgi.NewProblem(http.StatusConflict, "version mismatch"))
+	})
+	listener := scgitest.Start(srv)
+	defer srv.Shutdown(context.Background())
+
+	tests := []struct {
+		req                   *scgitest.RequestBuilder
+		status                int
+		contentType, expected string
+	}{
+		{scgitest.NewRequest("POST", "/transfer"), http.StatusForbidden, "application/problem+json",
+			`{"type":"https://example.com/probs/out-of-credit","title":"You do not have enough credit.","status":403,` +
+				`"detail":"Your balance is 30, but that costs 50.","instance":"/account/12345/msgs/abc","accounts":["/account/12345"],"balance":30}`},
+		{scgitest.NewRequest("GET", "/panic"), http.StatusConflict, "application/problem+json",
+			`{"type":"about:blank","title":"Conflict","status":409,"detail":"version mismatch"}`},
+		{scgitest.NewRequest("GET", "/missing").Header("Accept", "application/problem+json"), http.StatusNotFound, "application/problem+json",
+			`{"type":"about:blank","title":"Not Found","status":404}`},
+		{scgitest.NewRequest("GET", "/missing").Header("Accept", "text/html,*/*;q=0.8"), http.StatusNotFound, "text/plain", "404 Not Found"},
+		{scgitest.NewRequest("GET", "/missing").Header("Accept", "text/html"), http.StatusNotFound, "text/plain", "404 Not Found"},
+		{scgitest.NewRequest("GET", "/transfer").Header("Accept", "application/json"), http.StatusMethodNotAllowed, "application/problem+json",
+			`{"type":"about:blank","title":"Method Not Allowed","status":405}`},
+		{scgitest.NewRequest("B@D", "/").Header("Accept", "application/*"), http.StatusNotImplemented, "application/problem+json",
+			`{"type":"about:blank","title":"Not Implemented","status":501,` +
+				`"detail":"SCGI protocol error (unknown method), header \"REQUEST_METHOD\": Invalid header"}`},
+	}
+	for _, test := range tests {
+		resp, body, err := listener.Fetch(test.req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != test.status || resp.Header.Get("Content-Type") != test.contentType || string(body) != test.expected {
+			t.Errorf("%s: unexpected response %d %s %s", test.req.Params()[goscgi.RequestUriKey], resp.StatusCode, resp.Header.Get("Content-Type"), body)
+		}
+	}
+}
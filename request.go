@@ -6,6 +6,9 @@ package goscgi
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"io"
 	"mime"
 	"mime/multipart"
 	"net"
@@ -13,7 +16,6 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
-	"time"
 )
 
 type Request struct {
@@ -26,29 +28,45 @@ type Request struct {
 	Files         map[string][]*multipart.FileHeader
 	MultipartForm *multipart.Form
 	Cookies       []*http.Cookie
-	Method        byte
+	Params        map[string]string // path parameters, see Router
+	Method        string
 	IsAJAX        bool
 	UserAgent     string
-	Content       []byte
+	Content       []byte    // buffered content, only if Settings.BufferContent is set
+	Body          io.Reader // streams the content (ContentSize bytes, at most Settings.MaxBodySize) from the connection
 	ContentType   string
 	ContentSize   int64
 	Settings      *Settings // settings used while reading this request
+	boundary      string    // multipart/form-data boundary
+	ctx           context.Context
+	srv           *Server            // the server handling the request, for Server.ErrorHandler
+	wroteResponse bool               // the server started writing the response
+	allowed       []string           // the methods allowed for the path if no handler matched, see Router
+	forms         *[]*multipart.Form // the parsed multipart forms, shared by the request copies (see WithContext), removed by the server
 }
 
+// the standard methods; any other method (e.g. a WebDAV one) is accepted too
 const (
-	GET byte = iota
-	POST
-	PUT
-	DELETE
+	GET     = "GET"
+	HEAD    = "HEAD"
+	POST    = "POST"
+	PUT     = "PUT"
+	PATCH   = "PATCH"
+	DELETE  = "DELETE"
+	OPTIONS = "OPTIONS"
+	CONNECT = "CONNECT"
+	TRACE   = "TRACE"
 )
 
 const (
 	ContentTypeForm          = "application/x-www-form-urlencoded"
 	ContentTypeMultipartForm = "multipart/form-data"
+	ContentTypeJSON          = "application/json"
 )
 
 const (
 	ContentSizeKey   = "CONTENT_LENGTH"
+	ScgiKey          = "SCGI"
 	ContentTypeKey   = "CONTENT_TYPE"
 	RequestMethodKey = "REQUEST_METHOD"
 	RequestUriKey    = "REQUEST_URI"
@@ -57,13 +75,44 @@ const (
 	QueryStringKey   = "QUERY_STRING"
 	RemoteAddrKey    = "REMOTE_ADDR"
 	RemotePortKey    = "REMOTE_PORT"
+	RemoteUserKey    = "REMOTE_USER"
+	ServerNameKey    = "SERVER_NAME"
+	ServerPortKey    = "SERVER_PORT"
+	ServerProtoKey   = "SERVER_PROTOCOL"
+	RequestSchemeKey = "REQUEST_SCHEME"
+	HttpsKey         = "HTTPS"
+	ScriptNameKey    = "SCRIPT_NAME"
+	PathInfoKey      = "PATH_INFO"
+	HttpHostKey      = "HTTP_HOST"
 	RequestedWithKey = "HTTP_X_REQUESTED_WITH"
 	HttpCookieKey    = "HTTP_COOKIE"
+	HttpAcceptKey    = "HTTP_ACCEPT"
 	HttpUpgradeKey   = "HTTP_UPGRADE"
 	HttpUserAgentKey = "HTTP_USER_AGENT"
 )
 
+// PartFunc receives the parts of a multipart/form-data request, one by one,
+// as they are read from the connection. Unread part data is discarded after it returns.
+// If it returns an error, the handler isn't called and the error is answered by Server.ErrorHandler.
+type PartFunc func(req *Request, part *multipart.Part) error
+
+// ReadRequest reads the request header and parses the content (see Settings.BufferContent).
+// The errors are returned as *ProtocolError. The caller must call req.MultipartForm.RemoveAll
+// if req.MultipartForm is not nil, to remove the temporary files of the uploads.
 func ReadRequest(conn net.Conn, settings *Settings) (*Request, error) {
+	req, err := readRequest(conn, settings)
+	if err != nil {
+		return nil, err
+	}
+	if err = req.parseContent(nil); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// readRequest reads the request header, leaving the content unread in req.Body.
+// If the header was read but is not valid, req is returned with the error.
+func readRequest(conn net.Conn, settings *Settings) (*Request, error) {
 	req := Request{}
 	req.Connection = conn
 	req.Settings = settings
@@ -75,65 +124,46 @@ func ReadRequest(conn net.Conn, settings *Settings) (*Request, error) {
 
 	if contentSizeStr := req.Header.Get(ContentSizeKey); len(contentSizeStr) > 0 {
 		if req.ContentSize, err = strconv.ParseInt(contentSizeStr, 10, 0); err != nil {
-			return nil, err
+			return &req, newHeaderError(MalformedRequest, ContentSizeKey, err)
 		}
-		if req.ContentSize > settings.MaxContentSize {
-			return nil, InvalidContentErr
+		if req.ContentSize < 0 {
+			return &req, newHeaderError(MalformedRequest, ContentSizeKey, InvalidContentErr)
 		}
-		if req.ContentSize > 0 {
-			if contentType := req.Header.Get(ContentTypeKey); len(contentType) > 0 {
-				if contentType, params, err := mime.ParseMediaType(contentType); err != nil {
-					return nil, err
-				} else {
-					req.ContentType = contentType
-					switch contentType {
-					case ContentTypeForm:
-						if err = req.parseForm(); err != nil {
-							return nil, err
-						}
-					case ContentTypeMultipartForm:
-						if boundary, ok := params["boundary"]; !ok {
-							return nil, InvalidContentErr
-						} else if err = req.parseMultipartForm(boundary); err != nil {
-							return nil, err
-						}
-					default:
-						if err = req.readContent(); err != nil {
-							return nil, err
-						}
-					}
-				}
+	}
+	req.Body = newContentReader(conn, req.ContentSize, settings)
+	if settings.MaxBodySize > 0 && req.ContentSize > settings.MaxBodySize {
+		return &req, newHeaderError(ContentTooLarge, ContentSizeKey, InvalidContentErr)
+	}
+	if req.ContentSize > 0 {
+		if contentType := req.Header.Get(ContentTypeKey); len(contentType) > 0 {
+			if contentType, params, err := mime.ParseMediaType(contentType); err != nil {
+				return &req, newHeaderError(MalformedRequest, ContentTypeKey, err)
 			} else {
-				return nil, InvalidHeaderErr // invalid contentType
+				req.ContentType = contentType
+				req.boundary = params["boundary"]
 			}
+		} else {
+			return &req, newHeaderError(MalformedRequest, ContentTypeKey, InvalidHeaderErr) // missing contentType
 		}
 	}
 
 	// extract request method
-	methodStr := req.Header.Get(RequestMethodKey)
-	switch methodStr {
-	case "GET":
-		req.Method = GET
-	case "POST":
-		req.Method = POST
-	case "PUT":
-		req.Method = PUT
-	case "DELETE":
-		req.Method = DELETE
-	default:
-		return nil, InvalidHeaderErr // invalid method
+	if req.Method = req.Header.Get(RequestMethodKey); len(req.Method) == 0 {
+		return &req, newHeaderError(MalformedRequest, RequestMethodKey, InvalidHeaderErr)
+	} else if !isToken(req.Method) {
+		return &req, newHeaderError(UnknownMethod, RequestMethodKey, InvalidHeaderErr)
 	}
 
 	// extract request uri & parse url + query string
 	if req.RawURI = req.Header.Get(RequestUriKey); len(req.RawURI) > 0 {
 		if req.URL, err = url.ParseRequestURI(req.RawURI); err != nil {
-			return nil, err
+			return &req, newHeaderError(MalformedRequest, RequestUriKey, err)
 		}
 		if req.Query, err = url.ParseQuery(req.URL.RawQuery); err != nil {
-			return nil, err
+			return &req, newHeaderError(MalformedRequest, RequestUriKey, err)
 		}
 	} else {
-		return nil, InvalidHeaderErr
+		return &req, newHeaderError(MalformedRequest, RequestUriKey, InvalidHeaderErr)
 	}
 
 	req.parseCookies()
@@ -143,18 +173,55 @@ func ReadRequest(conn net.Conn, settings *Settings) (*Request, error) {
 	return &req, nil
 }
 
+// parseContent parses form contents; multipart forms are passed part by part to parts,
+// if not nil. Other contents are buffered only if Settings.BufferContent is set.
+// The errors are returned as *ProtocolError, except those returned by parts, as they are.
+func (req *Request) parseContent(parts PartFunc) error {
+	if req.ContentSize == 0 {
+		return nil
+	}
+	err := req.readBody(parts)
+	if reader, ok := req.Body.(*contentReader); ok && reader.err != nil {
+		return newConnError(reader.err, -1)
+	}
+	var protocolErr *ProtocolError
+	if err != nil && parts == nil && !errors.As(err, &protocolErr) {
+		return &ProtocolError{Offset: -1, Err: err}
+	}
+	return err
+}
+
+func (req *Request) readBody(parts PartFunc) error {
+	switch req.ContentType {
+	case ContentTypeForm:
+		return req.parseForm()
+	case ContentTypeMultipartForm:
+		if len(req.boundary) == 0 {
+			return newHeaderError(MalformedRequest, ContentTypeKey, InvalidContentErr)
+		}
+		if parts != nil {
+			return req.readParts(parts)
+		}
+		return req.parseMultipartForm()
+	default:
+		if req.Settings.BufferContent {
+			return req.readContent()
+		}
+	}
+	return nil
+}
+
+// readContent buffers the whole content in req.Content; req.Body then reads req.Content.
 func (req *Request) readContent() error {
+	if req.ContentSize > req.Settings.MaxContentSize {
+		return newHeaderError(ContentTooLarge, ContentSizeKey, InvalidContentErr)
+	}
 	content := make([]byte, req.ContentSize)
-	var alreadyRead int64
-	for alreadyRead < req.ContentSize {
-		req.Connection.SetReadDeadline(time.Now().Add(req.Settings.ReadTimeout))
-		if readCnt, err := req.Connection.Read(content[alreadyRead:]); err != nil {
-			return err
-		} else {
-			alreadyRead += int64(readCnt)
-		}
+	if _, err := io.ReadFull(req.Body, content); err != nil {
+		return err
 	}
 	req.Content = content
+	req.Body = bytes.NewReader(content)
 	return nil
 }
 
@@ -169,26 +236,73 @@ func (req *Request) parseForm() error {
 	return nil
 }
 
-func (req *Request) parseMultipartForm(boundary string) error {
-	// can't make it work without prebuffering in memory all the multipart content !!!
-	// gives error: 'multipart: Part Read: read tcp 127.0.0.1:38904: i/o timeout' everytime
-	//reader := multipart.NewReader(req.Connection, boundary)
-	var err error
-	if err = req.readContent(); err != nil {
-		return err
+func (req *Request) parseMultipartForm() error {
+	if req.ContentSize > req.Settings.MaxContentSize {
+		return newHeaderError(ContentTooLarge, ContentSizeKey, InvalidContentErr)
 	}
-	// we pass the content as input stream to multipart.NewReader()
-	reader := multipart.NewReader(bytes.NewBuffer(req.Content), boundary)
-	if multipartForm, err := reader.ReadForm(req.Settings.MaxContentSize); err != nil {
+	// the parts are read straight from the connection; ReadForm keeps up to MaxContentSize
+	// in memory, so with the content size checked above the files are rarely stored on disk;
+	// the temporary files are removed after the handler returns (see Server.handleConn)
+	reader := multipart.NewReader(req.Body, req.boundary)
+	if multipartForm, err := reader.ReadForm(req.Settings.MaxContentSize); err == multipart.ErrMessageTooLarge {
+		return &ProtocolError{Kind: ContentTooLarge, Offset: -1, Err: err}
+	} else if err != nil {
 		return err
 	} else {
 		req.MultipartForm = multipartForm
+		if req.forms != nil {
+			*req.forms = append(*req.forms, multipartForm)
+		}
 		req.Form = multipartForm.Value
 		req.Files = multipartForm.File
 	}
 	return nil
 }
 
+func (req *Request) readParts(parts PartFunc) error {
+	reader := multipart.NewReader(req.Body, req.boundary)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return &ProtocolError{Kind: MalformedRequest, Offset: -1, Err: err}
+		}
+		err = parts(req, part)
+		part.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Context returns the request context. For the requests handled by a Server it's cancelled
+// when the peer closes the connection, when the handler timeout expires or when Server.Shutdown
+// stops waiting for the running requests.
+// The peer is watched only after the whole content was read from Body; a peer shutting down
+// its write side after sending the request is taken for a closed connection.
+func (req *Request) Context() context.Context {
+	if req.ctx != nil {
+		return req.ctx
+	}
+	return context.Background()
+}
+
+// WithContext returns a shallow copy of req with its context changed to ctx.
+func (req *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("goscgi: nil context")
+	}
+	reqCopy := *req
+	reqCopy.ctx = ctx
+	return &reqCopy
+}
+
+// Param returns the value of the path parameter name (see Router).
+func (req *Request) Param(name string) string {
+	return req.Params[name]
+}
+
 func (req *Request) parseCookies() {
 	if cookies := req.Header.Get(HttpCookieKey); len(cookies) > 0 {
 		parts := strings.Split(cookies, ";")
@@ -206,6 +320,17 @@ func (req *Request) parseCookies() {
 	}
 }
 
+// isToken reports if str is an HTTP token (RFC 7230, section 3.2.6), as the methods are.
+func isToken(str string) bool {
+	for idx := 0; idx < len(str); idx++ {
+		if c := str[idx]; !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+			strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0) {
+			return false
+		}
+	}
+	return len(str) > 0
+}
+
 func unquoteStr(str string) string {
 	if len(str) > 1 && str[0] == '"' && str[len(str)-1] == '"' {
 		return str[1 : len(str)-1]
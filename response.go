@@ -5,6 +5,8 @@
 package goscgi
 
 import (
+	"bufio"
+	"errors"
 	"net"
 	"net/http"
 	"strconv"
@@ -12,11 +14,28 @@ import (
 )
 
 type Response struct {
-	ResponseCode []byte
+	ResponseCode []byte // the status line, e.g. RespCodeOK; see also Status
+	Status       int    // if not 0, the status code, replacing ResponseCode; see NewStatusResponse
 	ContentType  []byte
 	Content      []byte
 	Cookies      []*http.Cookie
 	Header       http.Header
+	// Stream, if not nil, is called after the headers are sent to write the content
+	// incrementally; Content is ignored and Content-Length is not sent (unless set in Header),
+	// the end of the content is marked by closing the connection.
+	Stream func(w *ResponseWriter) error
+}
+
+// ResponseWriter is the io.Writer used by Response.Stream to send the content.
+// Writes are buffered; Flush sends the buffered data to the connection.
+type ResponseWriter struct {
+	buff *bufio.Writer
+}
+
+// connWriter refreshes the write deadline before each write on conn.
+type connWriter struct {
+	conn    net.Conn
+	timeout time.Duration
 }
 
 var (
@@ -26,16 +45,59 @@ var (
 	crlf          = []byte{0x0d, 0x0a}
 	sep           = []byte{':'}
 
-	RespTypeHtml = []byte("text/html")
-	RespTypeText = []byte("text/plain")
-	RespTypeJson = []byte("text/json")
+	RespTypeHtml    = []byte("text/html")
+	RespTypeText    = []byte("text/plain")
+	RespTypeJson    = []byte("application/json; charset=utf-8")
+	RespTypeProblem = []byte("application/problem+json")
 
-	RespCodeOK            = []byte("200 OK")
-	RespCodeNotFound      = []byte("404 Not found")
-	RespCodeBadRequest    = []byte("400 Bad request")
-	RespCodeInternalError = []byte("500 Internal error")
+	RespCodeOK               = RespCode(http.StatusOK)
+	RespCodeNotFound         = RespCode(http.StatusNotFound)
+	RespCodeBadRequest       = RespCode(http.StatusBadRequest)
+	RespCodeMethodNotAllowed = RespCode(http.StatusMethodNotAllowed)
+	RespCodeRequestTimeout   = RespCode(http.StatusRequestTimeout)
+	RespCodeContentTooLarge  = RespCode(http.StatusRequestEntityTooLarge)
+	RespCodeHeaderTooLarge   = RespCode(http.StatusRequestHeaderFieldsTooLarge)
+	RespCodeInternalError    = RespCode(http.StatusInternalServerError)
+	RespCodeNotImplemented   = RespCode(http.StatusNotImplemented)
 )
 
+var InvalidStatusErr = errors.New("Invalid status code")
+
+// the reason phrases renamed by RFC 9110, http.StatusText has the old ones
+var reasonPhrases = map[int]string{
+	http.StatusRequestEntityTooLarge:        "Content Too Large",
+	http.StatusRequestURITooLong:            "URI Too Long",
+	http.StatusRequestedRangeNotSatisfiable: "Range Not Satisfiable",
+	http.StatusUnprocessableEntity:          "Unprocessable Content",
+}
+
+// ReasonPhrase returns the reason phrase of code, "" if unknown.
+// The phrases are those of http.StatusText, which has all the codes registered at IANA
+// (except 306, unused), with the names changed by RFC 9110; 418 stays "I'm a teapot".
+func ReasonPhrase(code int) string {
+	if phrase, ok := reasonPhrases[code]; ok {
+		return phrase
+	}
+	return http.StatusText(code)
+}
+
+// ValidStatus reports if code is a valid HTTP status code, 100 to 599.
+func ValidStatus(code int) bool {
+	return code >= 100 && code <= 599
+}
+
+// RespCode returns the status line for code, e.g. "404 Not Found".
+// It panics if code is not valid.
+func RespCode(code int) []byte {
+	if !ValidStatus(code) {
+		panic("goscgi: invalid status code " + strconv.Itoa(code))
+	}
+	if phrase := ReasonPhrase(code); len(phrase) > 0 {
+		return []byte(strconv.Itoa(code) + " " + phrase)
+	}
+	return []byte(strconv.Itoa(code))
+}
+
 func NewResponse(respCode, contentType []byte, content []byte, cookies ...*http.Cookie) *Response {
 	resp := Response{}
 	resp.ResponseCode = respCode
@@ -48,6 +110,46 @@ func NewResponse(respCode, contentType []byte, content []byte, cookies ...*http.
 	return &resp
 }
 
+// NewStatusResponse creates a Response with the status code, e.g. http.StatusCreated.
+// It panics if code is not valid.
+func NewStatusResponse(code int, contentType []byte, content []byte, cookies ...*http.Cookie) *Response {
+	resp := NewResponse(RespCode(code), contentType, content, cookies...)
+	resp.Status = code
+	return resp
+}
+
+// NewRedirect creates a redirect to location; code is one of 301, 302, 303, 307 & 308.
+func NewRedirect(code int, location string, cookies ...*http.Cookie) *Response {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		panic("goscgi: invalid redirect status code " + strconv.Itoa(code))
+	}
+	resp := NewStatusResponse(code, nil, nil, cookies...)
+	resp.Header.Set("Location", location)
+	return resp
+}
+
+// NewNoContent creates a 204 No Content response.
+func NewNoContent(cookies ...*http.Cookie) *Response {
+	return NewStatusResponse(http.StatusNoContent, nil, nil, cookies...)
+}
+
+// NewNotModified creates a 304 Not Modified response; set the ETag, Cache-Control...
+// headers that a 200 response would have.
+func NewNotModified() *Response {
+	return NewStatusResponse(http.StatusNotModified, nil, nil)
+}
+
+// NewStreamResponse creates a Response whose content is written by stream
+// (see Response.Stream).
+func NewStreamResponse(respCode, contentType []byte, stream func(w *ResponseWriter) error, cookies ...*http.Cookie) *Response {
+	resp := NewResponse(respCode, contentType, nil, cookies...)
+	resp.Stream = stream
+	return resp
+}
+
 func (resp *Response) SetCookie(cookie *http.Cookie) {
 	if cookie != nil {
 		resp.Header.Add("Set-Cookie", cookie.String())
@@ -55,36 +157,97 @@ func (resp *Response) SetCookie(cookie *http.Cookie) {
 }
 
 func (resp *Response) Write(conn net.Conn, timeout time.Duration) error {
-	var err error
-	// set a timeout for the first write
-	// just in case the user closed the connection
-	conn.SetWriteDeadline(time.Now().Add(timeout))
-	if _, err = conn.Write(status); err != nil {
-		return err
+	return resp.write(conn, timeout, false)
+}
+
+// statusLine returns the status line & code: from Status if set, otherwise from ResponseCode.
+func (resp *Response) statusLine() ([]byte, int, error) {
+	if resp.Status != 0 {
+		if !ValidStatus(resp.Status) {
+			return nil, 0, InvalidStatusErr
+		}
+		return RespCode(resp.Status), resp.Status, nil
 	}
-	conn.Write(resp.ResponseCode)
-	conn.Write(crlf)
-	conn.Write(contentType)
-	conn.Write(resp.ContentType)
-	conn.Write(crlf)
-	contentSize := int64(len(resp.Content))
-	if contentSize > 0 {
-		conn.Write(contentLength)
-		conn.Write([]byte(strconv.FormatInt(contentSize, 10)))
-		conn.Write(crlf)
+	if len(resp.ResponseCode) < 3 {
+		return nil, 0, InvalidStatusErr
 	}
+	code, err := strconv.Atoi(string(resp.ResponseCode[:3]))
+	if err != nil || !ValidStatus(code) || (len(resp.ResponseCode) > 3 && resp.ResponseCode[3] != ' ') {
+		return nil, 0, InvalidStatusErr
+	}
+	return resp.ResponseCode, code, nil
+}
 
-	if err = resp.Header.Write(conn); err != nil {
+// write sends the response; for HEAD requests (head) only the headers are sent,
+// including the Content-Length of the content.
+// The responses which can't have content (1xx, 204 & 304) are sent without content & Content-Length.
+// If the status is not valid, nothing is sent and InvalidStatusErr is returned.
+func (resp *Response) write(conn net.Conn, timeout time.Duration, head bool) error {
+	statusLine, code, err := resp.statusLine()
+	if err != nil {
 		return err
 	}
+	noContent := !bodyAllowed(code)
+	w := newResponseWriter(conn, timeout)
+	w.buff.Write(status)
+	w.buff.Write(statusLine)
+	w.buff.Write(crlf)
+	if len(resp.ContentType) > 0 {
+		w.buff.Write(contentType)
+		w.buff.Write(resp.ContentType)
+		w.buff.Write(crlf)
+	}
+	contentSize := int64(len(resp.Content))
+	if resp.Stream == nil && contentSize > 0 && !noContent {
+		w.buff.Write(contentLength)
+		w.buff.WriteString(strconv.FormatInt(contentSize, 10))
+		w.buff.Write(crlf)
+	}
+	header := resp.Header
+	if noContent && len(header.Get("Content-Length")) > 0 {
+		header = header.Clone()
+		header.Del("Content-Length")
+	}
+	header.Write(w.buff)
+	w.buff.Write(crlf)
 
-	conn.Write(crlf)
-	if contentSize > 0 {
-		// set a timeout for sending (large?) content
-		conn.SetWriteDeadline(time.Now().Add(timeout))
-		if _, err = conn.Write(resp.Content); err != nil {
+	if head || noContent {
+		// no content
+	} else if resp.Stream != nil {
+		// send the headers right away, the stream may take a while
+		if err = w.Flush(); err != nil {
 			return err
 		}
+		err = resp.Stream(w)
+	} else if contentSize > 0 {
+		_, err = w.Write(resp.Content)
 	}
-	return nil
+	if flushErr := w.Flush(); err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+func newResponseWriter(conn net.Conn, timeout time.Duration) *ResponseWriter {
+	return &ResponseWriter{bufio.NewWriter(&connWriter{conn, timeout})}
+}
+
+func (w *ResponseWriter) Write(data []byte) (int, error) {
+	return w.buff.Write(data)
+}
+
+func (w *ResponseWriter) WriteString(str string) (int, error) {
+	return w.buff.WriteString(str)
+}
+
+// Flush sends any buffered data to the connection.
+func (w *ResponseWriter) Flush() error {
+	return w.buff.Flush()
+}
+
+func (cw *connWriter) Write(data []byte) (int, error) {
+	// set a timeout for each write
+	// just in case the user closed the connection
+	cw.conn.SetWriteDeadline(time.Now().Add(cw.timeout))
+	return cw.conn.Write(data)
 }
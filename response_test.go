@@ -0,0 +1,66 @@
+// Copyright 2013 Liviu G. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package goscgi_test
+
+import (
+	"goscgi"
+	"goscgi/scgitest"
+	"net/http"
+	"testing"
+)
+
+func Test_Status(t *testing.T) {
+	noContent := goscgi.NewNoContent()
+	noContent.Content = []byte("ignored")
+	noContent.Header.Set("Content-Length", "7")
+	notModified := goscgi.NewNotModified()
+	notModified.Header.Set("Etag", `"v1"`)
+	tests := []struct {
+		resp     *goscgi.Response
+		expected string
+	}{
+		{goscgi.NewRedirect(http.StatusSeeOther, "/done"), "Status: 303 See Other\r\nLocation: /done\r\n\r\n"},
+		{noContent, "Status: 204 No Content\r\n\r\n"},
+		{notModified, "Status: 304 Not Modified\r\nEtag: \"v1\"\r\n\r\n"},
+		{goscgi.NewStatusResponse(http.StatusRequestEntityTooLarge, goscgi.RespTypeText, []byte("big")),
+			"Status: 413 Content Too Large\r\nContent-Type: text/plain\r\nContent-Length: 3\r\n\r\nbig"},
+		{goscgi.NewStatusResponse(299, nil, nil), "Status: 299\r\n\r\n"},
+		{&goscgi.Response{Status: 42}, ""},
+		{&goscgi.Response{ResponseCode: []byte("20 OK")}, ""},
+	}
+	for _, test := range tests {
+		rec := scgitest.NewRecorder(nil)
+		err := test.resp.Write(rec, 0)
+		if len(test.expected) == 0 && err != goscgi.InvalidStatusErr {
+			t.Errorf("%d %q: expected InvalidStatusErr, got %v", test.resp.Status, test.resp.ResponseCode, err)
+		} else if string(rec.Bytes()) != test.expected {
+			t.Errorf("expected %q, got %q", test.expected, rec.Bytes())
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for a redirect with status 200")
+		}
+	}()
+	goscgi.NewRedirect(http.StatusOK, "/")
+}
+
+func Test_ReasonPhrase(t *testing.T) {
+	tests := map[int]string{
+		http.StatusOK:                           "OK",
+		http.StatusRequestEntityTooLarge:        "Content Too Large",
+		http.StatusRequestURITooLong:            "URI Too Long",
+		http.StatusRequestedRangeNotSatisfiable: "Range Not Satisfiable",
+		http.StatusUnprocessableEntity:          "Unprocessable Content",
+		http.StatusTeapot:                       "I'm a teapot",
+		299:                                     "",
+	}
+	for code, expected := range tests {
+		if phrase := goscgi.ReasonPhrase(code); phrase != expected {
+			t.Errorf("%d: expected %q, got %q", code, expected, phrase)
+		}
+	}
+}
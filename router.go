@@ -0,0 +1,198 @@
+// Copyright 2013 Liviu G. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package goscgi
+
+import (
+	"sort"
+	"strings"
+)
+
+// Router selects the Handler of a request by path and method.
+// The path patterns can be:
+//
+//	"/about"          exact: matches only "/about", not "/about/team" (use "/about/" for that)
+//	"/static/"        prefix: matches "/static/" and everything below it
+//	"/users/{id}"     parameter: {id} matches one path segment, see Request.Param
+//	"/files/{path...}" wildcard: like a prefix, the rest of the path is stored in Request.Param("path")
+//
+// When several patterns match a path, the most specific one wins:
+// literal segments before parameters, parameters before prefixes & wildcards,
+// and longer prefixes before shorter ones.
+// If the pattern matches but no handler was added for the request method,
+// the server replies with 405 and the allowed methods in the Allow header.
+// HEAD requests go to the GET handler if there is no HEAD handler, and
+// OPTIONS requests without a handler get the allowed methods in the Allow header.
+type Router struct {
+	root       routeNode
+	handlers   []*Handler   // all the handlers, to rebuild their middleware chains
+	middleware []Middleware // the server middleware, see Server.Use
+}
+
+type routeNode struct {
+	static    map[string]*routeNode
+	param     *routeNode
+	paramName string
+	exact     *routeEntry // handlers for paths ending in this node
+	prefix    *routeEntry // handlers for paths continuing below this node
+}
+
+type routeEntry struct {
+	paramName string              // wildcard name, only for prefix entries
+	methods   map[string]*Handler // "" = any method
+}
+
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// AddHandler adds a handler for the requests matching path, whatever their method.
+func (router *Router) AddHandler(path string, handler HandlerFunc, options ...HandlerOption) {
+	router.add("", path, handler, options)
+}
+
+// AddMethodHandler adds a handler for the requests matching path and method (e.g. GET).
+func (router *Router) AddMethodHandler(method, path string, handler HandlerFunc, options ...HandlerOption) {
+	router.add(method, path, handler, options)
+}
+
+func (router *Router) add(method, path string, handlerFunc HandlerFunc, options []HandlerOption) {
+	if len(path) == 0 || path[0] != '/' {
+		panic("goscgi: invalid path pattern " + path)
+	}
+	if handlerFunc == nil {
+		panic("goscgi: nil handler for " + path)
+	}
+	if len(method) > 0 && !isToken(method) {
+		panic("goscgi: invalid method " + method + " for " + path)
+	}
+	handler := &Handler{Path: path, Method: method, Func: handlerFunc}
+	for _, option := range options {
+		option(handler)
+	}
+
+	node := &router.root
+	segments := strings.Split(path[1:], "/")
+	var entry **routeEntry
+	var wildcard string
+	for idx, segment := range segments {
+		last := idx == len(segments)-1
+		if last && len(segment) == 0 {
+			entry = &node.prefix // trailing '/'
+			break
+		}
+		if name, ok := paramName(segment); ok {
+			if strings.HasSuffix(name, "...") {
+				if !last {
+					panic("goscgi: wildcard must be the last segment in " + path)
+				}
+				wildcard = strings.TrimSuffix(name, "...")
+				entry = &node.prefix
+				break
+			}
+			if node.param == nil {
+				node.param = &routeNode{paramName: name}
+			} else if node.param.paramName != name {
+				panic("goscgi: parameter {" + name + "} in " + path + " conflicts with {" + node.param.paramName + "}")
+			}
+			node = node.param
+		} else {
+			if node.static == nil {
+				node.static = map[string]*routeNode{}
+			}
+			child, ok := node.static[segment]
+			if !ok {
+				child = &routeNode{}
+				node.static[segment] = child
+			}
+			node = child
+		}
+		if last {
+			entry = &node.exact
+		}
+	}
+
+	if *entry == nil {
+		*entry = &routeEntry{paramName: wildcard, methods: map[string]*Handler{}}
+	} else if (*entry).paramName != wildcard {
+		panic("goscgi: wildcard in " + path + " conflicts with a previous pattern")
+	}
+	if _, ok := (*entry).methods[method]; ok {
+		panic("goscgi: multiple handlers for " + method + " " + path)
+	}
+	(*entry).methods[method] = handler
+	router.handlers = append(router.handlers, handler)
+	handler.chained = router.chain(handler)
+}
+
+func paramName(segment string) (string, bool) {
+	if len(segment) > 2 && segment[0] == '{' && segment[len(segment)-1] == '}' {
+		return segment[1 : len(segment)-1], true
+	}
+	return "", false
+}
+
+// lookup returns the handler for method & path with the path parameters.
+// If the path matches but the method doesn't, handler is nil and allowed
+// contains the methods that have handlers.
+func (router *Router) lookup(method, path string) (handler *Handler, params map[string]string, allowed []string) {
+	if len(path) == 0 || path[0] != '/' {
+		return nil, nil, nil
+	}
+	params = map[string]string{}
+	entry := router.root.match(strings.Split(path[1:], "/"), params)
+	if entry == nil {
+		return nil, nil, nil
+	}
+	if handler = entry.methods[method]; handler == nil && method == HEAD {
+		handler = entry.methods[GET]
+	}
+	if handler == nil {
+		handler = entry.methods[""]
+	}
+	if handler == nil {
+		allowed = entry.allowed()
+	}
+	return handler, params, allowed
+}
+
+// allowed returns the methods having handlers, with HEAD if GET has one & OPTIONS.
+func (entry *routeEntry) allowed() []string {
+	var allowed []string
+	for name := range entry.methods {
+		allowed = append(allowed, name)
+	}
+	if _, ok := entry.methods[HEAD]; !ok && entry.methods[GET] != nil {
+		allowed = append(allowed, HEAD)
+	}
+	if _, ok := entry.methods[OPTIONS]; !ok {
+		allowed = append(allowed, OPTIONS)
+	}
+	sort.Strings(allowed)
+	return allowed
+}
+
+func (node *routeNode) match(segments []string, params map[string]string) *routeEntry {
+	if len(segments) == 0 {
+		return node.exact
+	}
+	if child, ok := node.static[segments[0]]; ok {
+		if entry := child.match(segments[1:], params); entry != nil {
+			return entry
+		}
+	}
+	if node.param != nil && len(segments[0]) > 0 {
+		if entry := node.param.match(segments[1:], params); entry != nil {
+			params[node.param.paramName] = segments[0]
+			return entry
+		}
+	}
+	if node.prefix != nil {
+		if len(node.prefix.paramName) > 0 {
+			params[node.prefix.paramName] = strings.Join(segments, "/")
+		}
+		return node.prefix
+	}
+	return nil
+}
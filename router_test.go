@@ -0,0 +1,117 @@
+// Copyright 2013 Liviu G. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package goscgi
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func Test_Router(t *testing.T) {
+	router := NewRouter()
+	for _, path := range []string{"/", "/static/", "/static/css/", "/users", "/users/{id}", "/users/me", "/files/{path...}"} {
+		router.AddHandler(path, requestHandler)
+	}
+	router.AddMethodHandler(POST, "/users/{id}/posts", requestHandler)
+	router.AddMethodHandler(PUT, "/users/{id}/posts", requestHandler)
+
+	tests := []struct {
+		method, path, pattern, params, allowed string
+	}{
+		{"GET", "/", "/", "map[]", ""},
+		{"GET", "/unknown/path", "/", "map[]", ""},
+		{"GET", "/static", "/", "map[]", ""},
+		{"GET", "/static/app.js", "/static/", "map[]", ""},
+		{"GET", "/static/css/app.css", "/static/css/", "map[]", ""},
+		{"GET", "/users", "/users", "map[]", ""},
+		{"GET", "/users/", "/", "map[]", ""},
+		{"GET", "/users/42", "/users/{id}", "map[id:42]", ""},
+		{"GET", "/users/me", "/users/me", "map[]", ""},
+		{"POST", "/users/42/posts", "/users/{id}/posts", "map[id:42]", ""},
+		{"GET", "/users/42/posts", "", "", "OPTIONS, POST, PUT"},
+		{"GET", "/files/a/b/c.txt", "/files/{path...}", "map[path:a/b/c.txt]", ""},
+	}
+	for _, test := range tests {
+		handler, params, allowed := router.lookup(test.method, test.path)
+		var pattern string
+		if handler != nil {
+			pattern = handler.Path
+		}
+		if pattern != test.pattern || (handler != nil && fmt.Sprint(params) != test.params) || strings.Join(allowed, ", ") != test.allowed {
+			t.Errorf("%s %s: got pattern %q, params %v, allowed %v; expected %q, %s, %q",
+				test.method, test.path, pattern, params, allowed, test.pattern, test.params, test.allowed)
+		}
+	}
+}
+
+func Test_Middleware(t *testing.T) {
+	var trace []string
+	tracer := func(name string) Middleware {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(req *Request) *Response {
+				trace = append(trace, name)
+				return next(req)
+			}
+		}
+	}
+	srv := NewServer(NewSettings())
+	srv.Use(tracer("server1"), tracer("server2"))
+	api := srv.Group("/api", tracer("api"))
+	v1 := api.Group("/v1/", tracer("v1"))
+	v1.AddMethodHandler(GET, "/users/{id}", func(req *Request) *Response {
+		trace = append(trace, "handler "+req.Param("id"))
+		return nil
+	}, WithMiddleware(tracer("route")))
+	api.Use(tracer("api late"))
+
+	handler, params, _ := srv.Router.lookup("GET", "/api/v1/users/42")
+	if handler == nil {
+		t.Fatal("no handler found for /api/v1/users/42")
+	}
+	handler.chained(&Request{Params: params})
+	expected := "server1,server2,api,api late,v1,route,handler 42"
+	if strings.Join(trace, ",") != expected {
+		t.Errorf("expected %q, got %q", expected, strings.Join(trace, ","))
+	}
+
+	// the 404 responses are new ones, the headers set by middleware don't pile up
+	srv.Use(func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) *Response {
+			resp := next(req)
+			resp.Header.Add("X-Seen", "1")
+			return resp
+		}
+	})
+	for idx := 0; idx < 2; idx++ {
+		if resp := srv.fallback(&Request{Header: http.Header{}}); len(resp.Header.Values("X-Seen")) != 1 {
+			t.Errorf("unexpected 404 headers %v", resp.Header)
+		}
+	}
+
+	// the middleware are called once per chain, their state is kept between requests
+	var calls, counts []int
+	srv = NewServer(NewSettings())
+	srv.AddHandler("/count", func(req *Request) *Response {
+		return nil
+	})
+	srv.Use(func(next HandlerFunc) HandlerFunc {
+		calls = append(calls, 1)
+		count := 0
+		return func(req *Request) *Response {
+			count++
+			counts = append(counts, count)
+			return next(req)
+		}
+	})
+	handler, _, _ = srv.Router.lookup("GET", "/count")
+	for idx := 0; idx < 3; idx++ {
+		handler.chained(&Request{})
+	}
+	if len(calls) != 2 || fmt.Sprint(counts) != "[1 2 3]" {
+		t.Errorf("expected 2 middleware calls (handler & 404) & counts [1 2 3], got %d & %v", len(calls), counts)
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2013 Liviu G. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package scgiclient sends requests to SCGI servers (http://www.python.ca/scgi/protocol.txt)
+// and parses their CGI style responses ("Status: 200 OK" instead of the HTTP status line).
+package scgiclient
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ContentSizeKey = "CONTENT_LENGTH"
+	ScgiKey        = "SCGI"
+)
+
+var (
+	InvalidParamErr    = errors.New("Invalid SCGI parameter")
+	ShortContentErr    = errors.New("Content shorter than the content length")
+	InvalidResponseErr = errors.New("Invalid SCGI response")
+	BodyTooLargeErr    = errors.New("Request body of unknown length too large to buffer")
+)
+
+// DefaultMaxBufferSize is the limit for the bodies of unknown length buffered by RoundTrip
+// when Client.MaxBufferSize is 0.
+const DefaultMaxBufferSize = 4 * 1024 * 1024
+
+// Client sends SCGI requests to the server at Address.
+// It's also an http.RoundTripper, so it can be used as the Transport of an http.Client.
+type Client struct {
+	Network     string        // "tcp" or "unix"
+	Address     string        // e.g. "127.0.0.1:8080" or "/tmp/goscgi.socket"
+	DialTimeout time.Duration // 0 = no timeout
+	// Params are added to the variables of the requests sent by RoundTrip
+	// (e.g. DOCUMENT_ROOT or SCRIPT_NAME); they don't replace the request variables.
+	Params map[string]string
+	// MaxBufferSize is the max size of a body of unknown length buffered by RoundTrip;
+	// bigger bodies fail with BodyTooLargeErr. 0 = DefaultMaxBufferSize
+	MaxBufferSize int64
+}
+
+// Do sends a request made of the CGI variables in params & contentLength bytes of body,
+// then reads the response. The caller must close the response body, which closes the connection.
+func (client *Client) Do(params map[string]string, body io.Reader, contentLength int64) (*http.Response, error) {
+	return client.DoContext(context.Background(), params, body, contentLength)
+}
+
+// DoContext is like Do, but the connection is closed when ctx is done.
+func (client *Client) DoContext(ctx context.Context, params map[string]string, body io.Reader, contentLength int64) (*http.Response, error) {
+	dialer := net.Dialer{Timeout: client.DialTimeout}
+	conn, err := dialer.DialContext(ctx, client.Network, client.Address)
+	if err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	resp, err := Exchange(conn, params, body, contentLength)
+	if err != nil {
+		stop()
+		conn.Close()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
+	}
+	resp.Body.(*connBody).stop = stop
+	return resp, nil
+}
+
+// Exchange sends the request on conn & reads the response.
+// The response body closes conn when closed.
+func Exchange(conn net.Conn, params map[string]string, body io.Reader, contentLength int64) (*http.Response, error) {
+	writeErr := WriteRequest(conn, params, body, contentLength)
+	// the server may answer (e.g. 413) without reading all the content,
+	// so even if the write failed we try to read the response
+	resp, err := ReadResponse(bufio.NewReader(conn), params["REQUEST_METHOD"])
+	if err != nil {
+		if writeErr != nil {
+			return nil, writeErr
+		}
+		return nil, err
+	}
+	resp.Body = &connBody{ReadCloser: resp.Body, conn: conn}
+	return resp, nil
+}
+
+// WriteRequest writes the SCGI header, as a netstring of NUL terminated names & values,
+// followed by contentLength bytes from body. As required by the protocol, CONTENT_LENGTH
+// comes first (its value in params is ignored) and SCGI=1 second; the other params follow
+// sorted by name.
+func WriteRequest(w io.Writer, params map[string]string, body io.Reader, contentLength int64) error {
+	if contentLength < 0 {
+		return ShortContentErr
+	}
+	names := make([]string, 0, len(params))
+	for name := range params {
+		if name != ContentSizeKey && name != ScgiKey {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var header []byte
+	appendParam := func(name, value string) error {
+		if len(name) == 0 || strings.IndexByte(name, 0) >= 0 || strings.IndexByte(value, 0) >= 0 {
+			return fmt.Errorf("%w: %q", InvalidParamErr, name)
+		}
+		header = append(header, name...)
+		header = append(header, 0)
+		header = append(header, value...)
+		header = append(header, 0)
+		return nil
+	}
+	appendParam(ContentSizeKey, strconv.FormatInt(contentLength, 10))
+	appendParam(ScgiKey, "1")
+	for _, name := range names {
+		if err := appendParam(name, params[name]); err != nil {
+			return err
+		}
+	}
+
+	buffWriter := bufio.NewWriter(w)
+	buffWriter.WriteString(strconv.Itoa(len(header)))
+	buffWriter.WriteByte(':')
+	buffWriter.Write(header)
+	buffWriter.WriteByte(',')
+	if contentLength > 0 {
+		if body == nil {
+			return ShortContentErr
+		}
+		if written, err := io.CopyN(buffWriter, body, contentLength); err != nil {
+			if err == io.EOF && written < contentLength {
+				err = ShortContentErr
+			}
+			return err
+		}
+	}
+	return buffWriter.Flush()
+}
+
+// ReadResponse reads a CGI response: headers, an empty line and the content.
+// The status comes from the Status header; without it, the status is 302 if
+// there is a Location header, 200 otherwise. The content ends at Content-Length,
+// or at the end of the stream if there is no Content-Length.
+// method is the request method; the responses to HEAD have no content.
+func ReadResponse(r *bufio.Reader, method string) (*http.Response, error) {
+	mimeHeader, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	resp := &http.Response{
+		Proto:      "HTTP/1.0",
+		ProtoMajor: 1,
+		Header:     http.Header(mimeHeader),
+		Close:      true,
+	}
+
+	statusStr := resp.Header.Get("Status")
+	resp.Header.Del("Status")
+	if len(statusStr) == 0 {
+		if len(resp.Header.Get("Location")) > 0 {
+			statusStr = "302 Found"
+		} else {
+			statusStr = "200 OK"
+		}
+	}
+	codeStr, _, _ := strings.Cut(statusStr, " ")
+	if resp.StatusCode, err = strconv.Atoi(codeStr); err != nil || len(codeStr) != 3 {
+		return nil, fmt.Errorf("%w: status %q", InvalidResponseErr, statusStr)
+	}
+	resp.Status = statusStr
+
+	resp.ContentLength = -1
+	if contentSizeStr := resp.Header.Get("Content-Length"); len(contentSizeStr) > 0 {
+		if resp.ContentLength, err = strconv.ParseInt(contentSizeStr, 10, 64); err != nil || resp.ContentLength < 0 {
+			return nil, fmt.Errorf("%w: Content-Length %q", InvalidResponseErr, contentSizeStr)
+		}
+	}
+	switch {
+	case method == "HEAD" || resp.StatusCode/100 == 1 || resp.StatusCode == http.StatusNoContent ||
+		resp.StatusCode == http.StatusNotModified:
+		resp.Body = http.NoBody
+	case resp.ContentLength >= 0:
+		resp.Body = io.NopCloser(io.LimitReader(r, resp.ContentLength))
+	default:
+		resp.Body = io.NopCloser(r)
+	}
+	return resp, nil
+}
+
+// connBody closes the connection together with the response body.
+type connBody struct {
+	io.ReadCloser
+	conn net.Conn
+	stop func() bool // stops watching the request context
+}
+
+func (body *connBody) Close() error {
+	if body.stop != nil {
+		body.stop()
+	}
+	body.ReadCloser.Close()
+	return body.conn.Close()
+}
@@ -0,0 +1,173 @@
+// Copyright 2013 Liviu G. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scgiclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	scgi "goscgi"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func Test_WriteRequest(t *testing.T) {
+	var buff bytes.Buffer
+	params := map[string]string{"REQUEST_URI": "/deepthought", "REQUEST_METHOD": "POST", "CONTENT_LENGTH": "99"}
+	if err := WriteRequest(&buff, params, strings.NewReader("What is the answer to life?"), 27); err != nil {
+		t.Fatal(err)
+	}
+	expected := "70:CONTENT_LENGTH\x0027\x00SCGI\x001\x00REQUEST_METHOD\x00POST\x00REQUEST_URI\x00/deepthought\x00," +
+		"What is the answer to life?"
+	if buff.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buff.String())
+	}
+	if err := WriteRequest(&buff, params, strings.NewReader("short"), 27); err != ShortContentErr {
+		t.Errorf("expected ShortContentErr, got %v", err)
+	}
+}
+
+func Test_ReadResponse(t *testing.T) {
+	tests := []struct {
+		resp, method, status, body string
+	}{
+		{"Status: 404 Not found\r\nContent-Length: 5\r\n\r\nmissing trailing", "GET", "404 Not found", "missi"},
+		{"Content-Type: text/plain\r\n\r\nstreamed until EOF", "GET", "200 OK", "streamed until EOF"},
+		{"Location: /elsewhere\r\n\r\n", "GET", "302 Found", ""},
+		{"Status: 200 OK\r\nContent-Length: 4\r\n\r\nbody", "HEAD", "200 OK", ""},
+	}
+	for _, test := range tests {
+		resp, err := ReadResponse(bufio.NewReader(strings.NewReader(test.resp)), test.method)
+		if err != nil {
+			t.Errorf("%q: %v", test.resp, err)
+			continue
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if resp.Status != test.status || string(body) != test.body || len(resp.Header.Get("Status")) > 0 {
+			t.Errorf("%q: got %q %q, expected %q %q", test.resp, resp.Status, body, test.status, test.body)
+		}
+	}
+	if _, err := ReadResponse(bufio.NewReader(strings.NewReader("Status: OK\r\n\r\n")), "GET"); err == nil {
+		t.Error("expected an error for an invalid status")
+	}
+}
+
+func Test_ClientDo(t *testing.T) {
+	srv := scgi.NewServer(scgi.NewSettings())
+	srv.AddMethodHandler(scgi.POST, "/echo", func(req *scgi.Request) *scgi.Response {
+		content, _ := io.ReadAll(req.Body)
+		resp := scgi.NewResponse(scgi.RespCodeOK, scgi.RespTypeText, content, &http.Cookie{Name: "echo", Value: "1"})
+		resp.Header.Set("X-Scgi", req.Header.Get("SCGI"))
+		return resp
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+
+	client := &Client{Network: "tcp", Address: listener.Addr().String()}
+	params := map[string]string{"REQUEST_URI": "/echo", "REQUEST_METHOD": "POST", "CONTENT_TYPE": "text/plain"}
+	resp, err := client.Do(params, strings.NewReader("hello"), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "hello" || resp.ContentLength != 5 {
+		t.Errorf("unexpected response %d %q, content length %d", resp.StatusCode, body, resp.ContentLength)
+	}
+	if resp.Header.Get("X-Scgi") != "1" {
+		t.Error("SCGI=1 was not received by the server")
+	}
+	if cookies := resp.Cookies(); len(cookies) != 1 || cookies[0].Name != "echo" {
+		t.Errorf("unexpected cookies %v", cookies)
+	}
+}
+
+func Test_RoundTrip(t *testing.T) {
+	srv := scgi.NewServer(scgi.NewSettings())
+	srv.AddHandler("/info", func(req *scgi.Request) *scgi.Response {
+		content, _ := io.ReadAll(req.Body)
+		var info bytes.Buffer
+		for _, name := range []string{"REQUEST_METHOD", "REQUEST_URI", "QUERY_STRING", "SERVER_NAME", "SERVER_PORT", "HTTP_X_CUSTOM", "CONTENT_TYPE"} {
+			info.WriteString(name + "=" + req.Header.Get(name) + "\n")
+		}
+		info.Write(content)
+		return scgi.NewResponse(scgi.RespCodeOK, scgi.RespTypeText, info.Bytes())
+	})
+	listener, err := net.Listen("unix", t.TempDir()+"/scgi.socket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+
+	client := &http.Client{Transport: &Client{Network: "unix", Address: listener.Addr().String()}}
+	req, _ := http.NewRequest("PUT", "http://backend.local:8080/info?a=1&b=2", strings.NewReader("the content"))
+	req.Header.Set("X-Custom", "custom value")
+	req.Header["X_Custom"] = []string{"spoofed value"}
+	req.Header.Set("Content-Type", "text/plain")
+	if params := RequestParams(req); params["HTTP_X_CUSTOM"] != "custom value" {
+		t.Errorf("X_Custom shadows X-Custom: %q", params["HTTP_X_CUSTOM"])
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	expected := "REQUEST_METHOD=PUT\nREQUEST_URI=/info?a=1&b=2\nQUERY_STRING=a=1&b=2\nSERVER_NAME=backend.local\n" +
+		"SERVER_PORT=8080\nHTTP_X_CUSTOM=custom value\nCONTENT_TYPE=text/plain\nthe content"
+	if resp.StatusCode != http.StatusOK || string(body) != expected {
+		t.Errorf("unexpected response %d\n%s\nexpected:\n%s", resp.StatusCode, body, expected)
+	}
+}
+
+func Test_RoundTripUnknownLength(t *testing.T) {
+	srv := scgi.NewServer(scgi.NewSettings())
+	srv.AddHandler("/echo", func(req *scgi.Request) *scgi.Response {
+		content, _ := io.ReadAll(req.Body)
+		return scgi.NewResponse(scgi.RespCodeOK, scgi.RespTypeText, content)
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+
+	client := &Client{Network: "tcp", Address: listener.Addr().String(), MaxBufferSize: 10}
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		pipeWriter.Write([]byte("piped "))
+		pipeWriter.Write([]byte("body"))
+		pipeWriter.Close()
+	}()
+	req, _ := http.NewRequest("POST", "http://backend.local/echo", pipeReader)
+	req.Header.Set("Content-Type", "text/plain")
+	if req.ContentLength != 0 {
+		t.Fatalf("expected a request of unknown length, got %d", req.ContentLength)
+	}
+	resp, err := client.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "piped body" {
+		t.Errorf("expected %q, got %q", "piped body", body)
+	}
+
+	req, _ = http.NewRequest("POST", "http://backend.local/echo", io.MultiReader(strings.NewReader("more than 10 bytes")))
+	req.Header.Set("Content-Type", "text/plain")
+	if _, err = client.RoundTrip(req); err != BodyTooLargeErr {
+		t.Errorf("expected BodyTooLargeErr, got %v", err)
+	}
+}
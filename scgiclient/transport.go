@@ -0,0 +1,135 @@
+// Copyright 2013 Liviu G. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scgiclient
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RoundTrip sends req to the SCGI server at client.Address (the host in req.URL is
+// only used for the SERVER_NAME & HTTP_HOST variables), implementing http.RoundTripper.
+// SCGI requires the content length up front, so a body of unknown length (req.ContentLength
+// < 0, or 0 with a body other than http.NoBody) is buffered in memory, up to MaxBufferSize bytes.
+func (client *Client) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := req.Body
+	contentLength := req.ContentLength
+	if body == nil || body == http.NoBody {
+		body, contentLength = nil, 0
+	} else {
+		defer body.Close()
+		if contentLength <= 0 {
+			content, err := client.bufferBody(body)
+			if err != nil {
+				return nil, err
+			}
+			body = io.NopCloser(bytes.NewReader(content))
+			contentLength = int64(len(content))
+		}
+	}
+	params := RequestParams(req)
+	for name, value := range client.Params {
+		if _, ok := params[name]; !ok {
+			params[name] = value
+		}
+	}
+	resp, err := client.DoContext(req.Context(), params, body, contentLength)
+	if err != nil {
+		return nil, err
+	}
+	resp.Request = req
+	return resp, nil
+}
+
+// bufferBody reads a body of unknown length, at most MaxBufferSize bytes.
+func (client *Client) bufferBody(body io.Reader) ([]byte, error) {
+	maxSize := client.MaxBufferSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxBufferSize
+	}
+	content, err := io.ReadAll(io.LimitReader(body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > maxSize {
+		return nil, BodyTooLargeErr
+	}
+	return content, nil
+}
+
+// RequestParams returns the CGI variables describing req (RFC 3875), the same ones
+// nginx sends with "include scgi_params": REQUEST_METHOD, REQUEST_URI, QUERY_STRING,
+// CONTENT_TYPE, DOCUMENT_URI, SERVER_PROTOCOL, REQUEST_SCHEME, HTTPS, REMOTE_ADDR,
+// REMOTE_PORT, SERVER_NAME, SERVER_PORT and the HTTP headers as HTTP_* variables.
+// It works both for outgoing requests (absolute req.URL) and for requests received
+// by an http.Server (req.Host, req.RemoteAddr, req.TLS). CONTENT_LENGTH is added by WriteRequest.
+// The headers whose names contain '_' are dropped, as nginx does by default (underscores_in_headers off):
+// both X_Foo and X-Foo would be HTTP_X_FOO, letting a client shadow a header trusted by the backend.
+func RequestParams(req *http.Request) map[string]string {
+	params := map[string]string{
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"SERVER_SOFTWARE":   "goscgi",
+		"REQUEST_METHOD":    req.Method,
+		"REQUEST_URI":       req.URL.RequestURI(),
+		"QUERY_STRING":      req.URL.RawQuery,
+		"DOCUMENT_URI":      req.URL.Path,
+		"SERVER_PROTOCOL":   req.Proto,
+		"REQUEST_SCHEME":    "http",
+	}
+	if len(req.Method) == 0 {
+		params["REQUEST_METHOD"] = http.MethodGet
+	}
+	if len(req.Proto) == 0 {
+		params["SERVER_PROTOCOL"] = "HTTP/1.1"
+	}
+	if req.TLS != nil || req.URL.Scheme == "https" {
+		params["REQUEST_SCHEME"] = "https"
+		params["HTTPS"] = "on"
+	}
+
+	host := req.Host
+	if len(host) == 0 {
+		host = req.URL.Host
+	}
+	if len(host) > 0 {
+		params["HTTP_HOST"] = host
+		serverName, serverPort, err := net.SplitHostPort(host)
+		if err != nil {
+			serverName = host
+			if serverPort = "80"; params["REQUEST_SCHEME"] == "https" {
+				serverPort = "443"
+			}
+		}
+		params["SERVER_NAME"] = serverName
+		params["SERVER_PORT"] = serverPort
+	}
+	if remoteAddr, remotePort, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		params["REMOTE_ADDR"] = remoteAddr
+		params["REMOTE_PORT"] = remotePort
+	}
+
+	for name, values := range req.Header {
+		switch name = http.CanonicalHeaderKey(name); name {
+		case "Content-Type":
+			params["CONTENT_TYPE"] = strings.Join(values, ", ")
+			continue
+		case "Content-Length", "Host", "Proxy":
+			// Proxy: https://httpoxy.org
+			continue
+		}
+		if strings.Contains(name, "_") {
+			continue
+		}
+		separator := ", "
+		if name == "Cookie" {
+			separator = "; "
+		}
+		params["HTTP_"+strings.ToUpper(strings.ReplaceAll(name, "-", "_"))] = strings.Join(values, separator)
+	}
+	return params
+}
@@ -0,0 +1,325 @@
+// Copyright 2013 Liviu G. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package scgiproxy is an HTTP front proxy for one or more SCGI backends,
+// taking the place of nginx's scgi_pass & upstream: it balances the requests
+// over the backends, checks their health and retries the failed requests.
+package scgiproxy
+
+import (
+	"context"
+	"errors"
+	"goscgi/scgiclient"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Policy int
+
+const (
+	RoundRobin       Policy = iota // each backend in turn
+	LeastConnections               // the backend with the fewest requests in progress
+)
+
+var NoBackendErr = errors.New("No backend available")
+
+// the headers of a single connection, not to be relayed (RFC 7230, section 6.1)
+var hopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// Backend is an SCGI server the proxy sends requests to.
+type Backend struct {
+	Client *scgiclient.Client
+
+	mutex        sync.Mutex
+	active       int       // requests in progress
+	fails        int       // consecutive connection failures
+	unhealthy    bool      // the last health check failed
+	ejectedUntil time.Time // skipped until then, after MaxFails failures
+}
+
+// NewBackend creates a backend for address: "host:port" or "unix:/path/to/socket",
+// the same syntax as nginx's scgi_pass.
+func NewBackend(address string) *Backend {
+	client := &scgiclient.Client{Network: "tcp", Address: address, DialTimeout: 5 * time.Second}
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		client.Network, client.Address = "unix", path
+	}
+	return &Backend{Client: client}
+}
+
+func (backend *Backend) String() string {
+	return backend.Client.Network + ":" + backend.Client.Address
+}
+
+// Proxy is an http.Handler sending the requests to its Backends.
+// A backend is ejected (skipped) after MaxFails consecutive connection failures,
+// for EjectTime or until it passes a health check (see CheckHealth).
+// If all backends are ejected or unhealthy, the requests are sent to them anyway.
+// The idempotent requests without content that fail to connect are retried
+// on other backends, at most Retries times.
+type Proxy struct {
+	Backends       []*Backend
+	Policy         Policy
+	MaxFails       int
+	EjectTime      time.Duration
+	Retries        int
+	HealthPath     string        // the path requested by CheckHealth; "" = no health checks
+	HealthInterval time.Duration // the interval between health checks, also their timeout; 0 = 5 seconds
+
+	mutex sync.Mutex
+	next  int // the round robin position
+}
+
+// NewProxy creates a proxy for the backend addresses (see NewBackend), with
+// default settings: eject after 3 failures for 10 seconds, retry once,
+// check /health every 5 seconds if CheckHealth is started.
+func NewProxy(policy Policy, addresses ...string) *Proxy {
+	proxy := &Proxy{
+		Policy:         policy,
+		MaxFails:       3,
+		EjectTime:      10 * time.Second,
+		Retries:        1,
+		HealthPath:     "/health",
+		HealthInterval: 5 * time.Second,
+	}
+	for _, address := range addresses {
+		proxy.Backends = append(proxy.Backends, NewBackend(address))
+	}
+	return proxy
+}
+
+func (proxy *Proxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	resp, backend, err := proxy.roundTrip(req)
+	if err != nil {
+		if req.Context().Err() == nil {
+			log.Println("scgiproxy:", req.Method, req.RequestURI, err.Error())
+			http.Error(w, "502 Bad Gateway", http.StatusBadGateway)
+		}
+		return
+	}
+	defer backend.done()
+	defer resp.Body.Close()
+
+	for _, name := range hopHeaders {
+		resp.Header.Del(name)
+	}
+	for name, values := range resp.Header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(resp.StatusCode)
+	if err = relay(w, resp.Body); err != nil {
+		log.Println("scgiproxy, relay:", req.Method, req.RequestURI, backend.String(), err.Error())
+	}
+}
+
+// roundTrip sends req to a backend, trying other backends if allowed.
+// If successful, backend.done must be called after reading the response.
+func (proxy *Proxy) roundTrip(req *http.Request) (*http.Response, *Backend, error) {
+	retries := 0
+	if isIdempotent(req.Method) && (req.Body == nil || req.Body == http.NoBody) {
+		retries = proxy.Retries
+	}
+	tried := map[*Backend]bool{}
+	var err error
+	for attempt := 0; attempt <= retries; attempt++ {
+		backend := proxy.pick(tried)
+		if backend == nil {
+			break
+		}
+		tried[backend] = true
+		var resp *http.Response
+		if resp, err = backend.Client.RoundTrip(req); err == nil {
+			proxy.succeeded(backend)
+			return resp, backend, nil
+		}
+		backend.done()
+		if req.Context().Err() != nil {
+			return nil, nil, err // the client went away, not the backend's fault
+		}
+		log.Println("scgiproxy:", backend.String(), err.Error())
+		if isConnectErr(err) {
+			proxy.failed(backend)
+		}
+	}
+	if err == nil {
+		err = NoBackendErr
+	}
+	return nil, nil, err
+}
+
+// pick selects a backend not in tried & counts the request as active on it.
+func (proxy *Proxy) pick(tried map[*Backend]bool) *Backend {
+	proxy.mutex.Lock()
+	defer proxy.mutex.Unlock()
+	now := time.Now()
+	var picked, fallback *Backend
+	pickedIdx, fallbackIdx, pickedActive := 0, 0, 0
+	count := len(proxy.Backends)
+	for offset := 0; offset < count; offset++ {
+		idx := (proxy.next + offset) % count
+		backend := proxy.Backends[idx]
+		if tried[backend] {
+			continue
+		}
+		backend.mutex.Lock()
+		available := !backend.unhealthy && !now.Before(backend.ejectedUntil)
+		active := backend.active
+		backend.mutex.Unlock()
+		if !available {
+			if fallback == nil {
+				fallback, fallbackIdx = backend, idx
+			}
+			continue
+		}
+		if picked == nil || (proxy.Policy == LeastConnections && active < pickedActive) {
+			picked, pickedIdx, pickedActive = backend, idx, active
+		}
+		if proxy.Policy == RoundRobin {
+			break
+		}
+	}
+	if picked == nil {
+		picked, pickedIdx = fallback, fallbackIdx // all ejected or unhealthy: better try than fail
+	}
+	if picked == nil {
+		return nil
+	}
+	proxy.next = (pickedIdx + 1) % count // the next one after the backend used
+	picked.mutex.Lock()
+	picked.active++
+	picked.mutex.Unlock()
+	return picked
+}
+
+func (proxy *Proxy) succeeded(backend *Backend) {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+	backend.fails = 0
+}
+
+func (proxy *Proxy) failed(backend *Backend) {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+	if backend.fails++; proxy.MaxFails > 0 && backend.fails >= proxy.MaxFails {
+		backend.fails = 0
+		backend.ejectedUntil = time.Now().Add(proxy.EjectTime)
+		log.Println("scgiproxy:", backend.String(), "ejected for", proxy.EjectTime)
+	}
+}
+
+func (backend *Backend) done() {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+	backend.active--
+}
+
+// used when HealthInterval is 0
+const defaultHealthInterval = 5 * time.Second
+
+// CheckHealth requests HealthPath from every backend each HealthInterval, until ctx is done.
+// The backends answering with an error (or a 5xx status) don't receive requests until
+// they pass a check; passing a check also ends an ejection.
+func (proxy *Proxy) CheckHealth(ctx context.Context) {
+	if len(proxy.HealthPath) == 0 {
+		return
+	}
+	ticker := time.NewTicker(proxy.healthInterval())
+	defer ticker.Stop()
+	for {
+		proxy.checkHealth(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (proxy *Proxy) checkHealth(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, backend := range proxy.Backends {
+		wg.Add(1)
+		go func(backend *Backend) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, proxy.healthInterval())
+			defer cancel()
+			healthy := false
+			req, err := http.NewRequestWithContext(checkCtx, http.MethodGet, "http://localhost"+proxy.HealthPath, nil)
+			if err == nil {
+				var resp *http.Response
+				if resp, err = backend.Client.RoundTrip(req); err == nil {
+					resp.Body.Close()
+					healthy = resp.StatusCode < http.StatusInternalServerError
+				}
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			backend.mutex.Lock()
+			defer backend.mutex.Unlock()
+			if healthy == backend.unhealthy {
+				log.Println("scgiproxy:", backend.String(), "healthy:", healthy)
+			}
+			backend.unhealthy = !healthy
+			if healthy {
+				backend.fails = 0
+				backend.ejectedUntil = time.Time{}
+			}
+		}(backend)
+	}
+	wg.Wait()
+}
+
+func (proxy *Proxy) healthInterval() time.Duration {
+	if proxy.HealthInterval == 0 {
+		return defaultHealthInterval
+	}
+	return proxy.HealthInterval
+}
+
+// isConnectErr reports if err means the backend couldn't be reached: the connection
+// or the sending of the request failed, as opposed to an invalid response.
+func isConnectErr(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && (opErr.Op == "dial" || opErr.Op == "write")
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// relay copies the response content, flushing it as soon as it arrives,
+// so streamed responses are not delayed.
+func relay(w http.ResponseWriter, content io.Reader) error {
+	flusher, _ := w.(http.Flusher)
+	buff := make([]byte, 32*1024)
+	for {
+		readCnt, err := content.Read(buff)
+		if readCnt > 0 {
+			if _, writeErr := w.Write(buff[:readCnt]); writeErr != nil {
+				return writeErr
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
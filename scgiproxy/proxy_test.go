@@ -0,0 +1,174 @@
+// Copyright 2013 Liviu G. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scgiproxy
+
+import (
+	"context"
+	scgi "goscgi"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// startBackend serves name on /name & the health status on /health.
+func startBackend(t *testing.T, name string, health *[]byte) (*scgi.Server, string) {
+	srv := scgi.NewServer(scgi.NewSettings())
+	srv.AddHandler("/name", func(req *scgi.Request) *scgi.Response {
+		return scgi.NewResponse(scgi.RespCodeOK, scgi.RespTypeText, []byte(name))
+	})
+	srv.AddHandler("/health", func(req *scgi.Request) *scgi.Response {
+		return scgi.NewResponse(*health, scgi.RespTypeText, nil)
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(listener)
+	t.Cleanup(func() { srv.Shutdown(context.Background()) })
+	return srv, listener.Addr().String()
+}
+
+func getNames(t *testing.T, url string, count int) string {
+	names := ""
+	for idx := 0; idx < count; idx++ {
+		resp, err := http.Get(url + "/name")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status %d %q", resp.StatusCode, body)
+		}
+		names += string(body)
+	}
+	return names
+}
+
+func Test_Proxy(t *testing.T) {
+	healthA, healthB := scgi.RespCodeOK, scgi.RespCodeOK
+	_, addrA := startBackend(t, "a", &healthA)
+	srvB, addrB := startBackend(t, "b", &healthB)
+	proxy := NewProxy(RoundRobin, addrA, addrB)
+	proxy.MaxFails = 1
+	front := httptest.NewServer(proxy)
+	defer front.Close()
+
+	if names := getNames(t, front.URL, 4); names != "abab" {
+		t.Errorf("round robin: got %q", names)
+	}
+
+	// unhealthy b gets no requests
+	healthB = scgi.RespCodeInternalError
+	proxy.checkHealth(context.Background())
+	if names := getNames(t, front.URL, 3); names != "aaa" {
+		t.Errorf("unhealthy backend: got %q", names)
+	}
+	healthB = scgi.RespCodeOK
+	proxy.checkHealth(context.Background())
+	if names := getNames(t, front.URL, 2); names != "ab" && names != "ba" {
+		t.Errorf("healthy again: got %q", names)
+	}
+
+	// b is gone: its requests are retried on a, then b is ejected
+	srvB.Shutdown(context.Background())
+	if names := getNames(t, front.URL, 4); names != "aaaa" {
+		t.Errorf("retry & ejection: got %q", names)
+	}
+	if ejected := !time.Now().Before(proxy.Backends[1].ejectedUntil); ejected {
+		t.Error("the failed backend was not ejected")
+	}
+
+	// POST is not retried
+	proxy.Backends[1].ejectedUntil = time.Time{}
+	proxy.next = 1
+	resp, err := http.Post(front.URL+"/name", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("expected 502 for a POST to a failed backend, got %d", resp.StatusCode)
+	}
+}
+
+func Test_LeastConnections(t *testing.T) {
+	proxy := NewProxy(LeastConnections, "127.0.0.1:1", "127.0.0.1:2", "127.0.0.1:3")
+	proxy.Backends[0].active, proxy.Backends[1].active, proxy.Backends[2].active = 2, 0, 1
+	if backend := proxy.pick(nil); backend != proxy.Backends[1] {
+		t.Errorf("expected the idle backend, got %s", backend)
+	}
+	if backend := proxy.pick(map[*Backend]bool{proxy.Backends[1]: true}); backend != proxy.Backends[2] {
+		t.Errorf("expected the least busy untried backend, got %s", backend)
+	}
+}
+
+func Test_RoundRobinSkipsEjected(t *testing.T) {
+	proxy := NewProxy(RoundRobin, "127.0.0.1:1", "127.0.0.1:2", "127.0.0.1:3")
+	proxy.Backends[1].ejectedUntil = time.Now().Add(time.Minute)
+	names := ""
+	for idx := 0; idx < 4; idx++ {
+		backend := proxy.pick(nil)
+		backend.done()
+		names += backend.Client.Address[len(backend.Client.Address)-1:]
+	}
+	if names != "1313" {
+		t.Errorf("expected the ejected backend to be skipped evenly, got %q", names)
+	}
+}
+
+func Test_FailureCounting(t *testing.T) {
+	// a backend answering with an invalid response is reachable: it's not ejected
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			scgi.ReadRequest(conn, scgi.NewSettings())
+			conn.Write([]byte("Status: invalid\r\n\r\n"))
+			conn.Close()
+		}
+	}()
+	proxy := NewProxy(RoundRobin, listener.Addr().String())
+	proxy.MaxFails = 1
+	req := httptest.NewRequest(http.MethodGet, "/name", nil)
+	if _, _, err = proxy.roundTrip(req); err == nil {
+		t.Fatal("expected an invalid response error")
+	}
+	if !proxy.Backends[0].ejectedUntil.IsZero() {
+		t.Error("a backend sending an invalid response was ejected")
+	}
+
+	// a backend refusing the connections is ejected
+	listener.Close()
+	if _, _, err = proxy.roundTrip(req); err == nil {
+		t.Fatal("expected a connection error")
+	}
+	if proxy.Backends[0].ejectedUntil.IsZero() {
+		t.Error("a backend refusing the connections was not ejected")
+	}
+}
+
+func Test_CheckHealthDefaultInterval(t *testing.T) {
+	health := scgi.RespCodeOK
+	_, addr := startBackend(t, "a", &health)
+	proxy := &Proxy{Backends: []*Backend{NewBackend(addr)}, HealthPath: "/health"} // HealthInterval = 0
+	proxy.Backends[0].unhealthy = true
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	proxy.CheckHealth(ctx)
+	if proxy.Backends[0].unhealthy {
+		t.Error("the backend should pass the health check")
+	}
+}
@@ -0,0 +1,276 @@
+// Copyright 2013 Liviu G. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package scgitest provides utilities for testing goscgi handlers without sockets:
+// a request builder, a recorder capturing the responses and an in-memory listener
+// to run a whole Server.
+package scgitest
+
+import (
+	"bufio"
+	"bytes"
+	"goscgi"
+	"goscgi/scgiclient"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RequestBuilder builds the CGI variables & content of an SCGI request,
+// as nginx would send them.
+type RequestBuilder struct {
+	params     map[string]string
+	content    []byte
+	pathParams map[string]string
+}
+
+// NewRequest starts building a request for method & uri (path and query string).
+func NewRequest(method, uri string) *RequestBuilder {
+	path, query, _ := strings.Cut(uri, "?")
+	return &RequestBuilder{params: map[string]string{
+		goscgi.RequestMethodKey: method,
+		goscgi.RequestUriKey:    uri,
+		goscgi.DocumentUriKey:   path,
+		goscgi.QueryStringKey:   query,
+		"SERVER_PROTOCOL":       "HTTP/1.1",
+		"SERVER_NAME":           "localhost",
+		"SERVER_PORT":           "80",
+		goscgi.RemoteAddrKey:    "127.0.0.1",
+		goscgi.RemotePortKey:    "50000",
+	}}
+}
+
+// Param sets a CGI variable, e.g. Param("HTTPS", "on").
+func (b *RequestBuilder) Param(name, value string) *RequestBuilder {
+	b.params[name] = value
+	return b
+}
+
+// Header sets an HTTP header, sent as an HTTP_* variable.
+func (b *RequestBuilder) Header(name, value string) *RequestBuilder {
+	return b.Param("HTTP_"+strings.ToUpper(strings.ReplaceAll(name, "-", "_")), value)
+}
+
+// Cookie adds a cookie to the HTTP_COOKIE variable.
+func (b *RequestBuilder) Cookie(cookie *http.Cookie) *RequestBuilder {
+	if cookies := b.params[goscgi.HttpCookieKey]; len(cookies) > 0 {
+		return b.Param(goscgi.HttpCookieKey, cookies+"; "+cookie.String())
+	}
+	return b.Param(goscgi.HttpCookieKey, cookie.String())
+}
+
+// Content sets the request content & its type.
+func (b *RequestBuilder) Content(contentType string, content []byte) *RequestBuilder {
+	b.content = content
+	return b.Param(goscgi.ContentTypeKey, contentType)
+}
+
+// Form sets the content to the url-encoded form.
+func (b *RequestBuilder) Form(form url.Values) *RequestBuilder {
+	return b.Content(goscgi.ContentTypeForm, []byte(form.Encode()))
+}
+
+// PathParam sets a path parameter of the request returned by Build,
+// which doesn't go through a Router.
+func (b *RequestBuilder) PathParam(name, value string) *RequestBuilder {
+	if b.pathParams == nil {
+		b.pathParams = make(map[string]string)
+	}
+	b.pathParams[name] = value
+	return b
+}
+
+// Params returns the CGI variables, without CONTENT_LENGTH & SCGI (see scgiclient.WriteRequest).
+func (b *RequestBuilder) Params() map[string]string {
+	return b.params
+}
+
+// Bytes returns the request encoded as sent on an SCGI connection.
+func (b *RequestBuilder) Bytes() []byte {
+	var buff bytes.Buffer
+	scgiclient.WriteRequest(&buff, b.params, bytes.NewReader(b.content), int64(len(b.content)))
+	return buff.Bytes()
+}
+
+// Build reads the request with goscgi.ReadRequest from a Recorder, so the
+// content is parsed according to settings (nil = goscgi.NewSettings()).
+// The request's Connection is the Recorder: see NewRecorder.
+func (b *RequestBuilder) Build(settings *goscgi.Settings) (*goscgi.Request, error) {
+	if settings == nil {
+		settings = goscgi.NewSettings()
+	}
+	req, err := goscgi.ReadRequest(NewRecorder(b.Bytes()), settings)
+	if err != nil {
+		return nil, err
+	}
+	req.Params = b.pathParams
+	return req, nil
+}
+
+// Recorder is a net.Conn reading the given request bytes & recording everything written,
+// e.g. by Response.Write.
+type Recorder struct {
+	in     io.Reader
+	mutex  sync.Mutex
+	out    bytes.Buffer
+	closed bool
+}
+
+// NewRecorder creates a recorder, reading request (may be nil).
+func NewRecorder(request []byte) *Recorder {
+	return &Recorder{in: bytes.NewReader(request)}
+}
+
+func (rec *Recorder) Read(data []byte) (int, error) {
+	return rec.in.Read(data)
+}
+
+func (rec *Recorder) Write(data []byte) (int, error) {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	if rec.closed {
+		return 0, net.ErrClosed
+	}
+	return rec.out.Write(data)
+}
+
+func (rec *Recorder) Close() error {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	rec.closed = true
+	return nil
+}
+
+// Bytes returns the raw data written so far.
+func (rec *Recorder) Bytes() []byte {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	return append([]byte(nil), rec.out.Bytes()...)
+}
+
+// Result parses the recorded data as a CGI response: status, headers, cookies & content.
+// method is the request method (HEAD responses have no content).
+func (rec *Recorder) Result(method string) (*http.Response, error) {
+	return scgiclient.ReadResponse(bufio.NewReader(bytes.NewReader(rec.Bytes())), method)
+}
+
+func (rec *Recorder) LocalAddr() net.Addr                { return memAddr{} }
+func (rec *Recorder) RemoteAddr() net.Addr               { return memAddr{} }
+func (rec *Recorder) SetDeadline(t time.Time) error      { return nil }
+func (rec *Recorder) SetReadDeadline(t time.Time) error  { return nil }
+func (rec *Recorder) SetWriteDeadline(t time.Time) error { return nil }
+
+// Record writes resp to a Recorder & parses the result.
+func Record(resp *goscgi.Response) (*http.Response, error) {
+	rec := NewRecorder(nil)
+	if err := resp.Write(rec, 0); err != nil {
+		return nil, err
+	}
+	return rec.Result(http.MethodGet)
+}
+
+type memAddr struct{}
+
+func (memAddr) Network() string { return "memory" }
+func (memAddr) String() string  { return "scgitest" }
+
+// Listener is an in-memory net.Listener, its connections are net.Pipe pairs.
+type Listener struct {
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func NewListener() *Listener {
+	return &Listener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+// Start serves srv on a new Listener; stop it with srv.Shutdown.
+func Start(srv *goscgi.Server) *Listener {
+	listener := NewListener()
+	go srv.Serve(listener)
+	return listener
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *Listener) Addr() net.Addr {
+	return memAddr{}
+}
+
+// Dial connects to the listener; it blocks until the connection is accepted.
+func (l *Listener) Dial() (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.closed:
+		client.Close()
+		server.Close()
+		return nil, net.ErrClosed
+	}
+}
+
+// Do sends the request to the listener & reads the response.
+// The request is written concurrently, net.Pipe being unbuffered.
+// The caller must close the response body, which closes the connection.
+func (l *Listener) Do(b *RequestBuilder) (*http.Response, error) {
+	conn, err := l.Dial()
+	if err != nil {
+		return nil, err
+	}
+	go scgiclient.WriteRequest(conn, b.params, bytes.NewReader(b.content), int64(len(b.content)))
+	resp, err := scgiclient.ReadResponse(bufio.NewReader(conn), b.params[goscgi.RequestMethodKey])
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body = &connBody{ReadCloser: resp.Body, conn: conn}
+	return resp, nil
+}
+
+// Fetch sends the request to the listener, reads the whole response content
+// & closes the connection.
+func (l *Listener) Fetch(b *RequestBuilder) (*http.Response, []byte, error) {
+	resp, err := l.Do(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	return resp, content, err
+}
+
+// connBody closes the connection together with the response body.
+type connBody struct {
+	io.ReadCloser
+	conn net.Conn
+}
+
+func (body *connBody) Close() error {
+	body.ReadCloser.Close()
+	return body.conn.Close()
+}
+
+// check the interfaces
+var (
+	_ net.Conn     = (*Recorder)(nil)
+	_ net.Listener = (*Listener)(nil)
+)
@@ -0,0 +1,76 @@
+// Copyright 2013 Liviu G. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scgitest
+
+import (
+	"context"
+	"goscgi"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func formHandler(req *goscgi.Request) *goscgi.Response {
+	content := req.URL.Path + " " + req.Query.Get("arg1") + " " + req.Form.Get("name") + " " + req.Param("id")
+	resp := goscgi.NewResponse(goscgi.RespCodeOK, goscgi.RespTypeText, []byte(content))
+	if len(req.Cookies) > 0 {
+		resp.SetCookie(&http.Cookie{Name: "seen", Value: req.Cookies[0].Value})
+	}
+	return resp
+}
+
+func Test_Handler(t *testing.T) {
+	req, err := NewRequest("POST", "/cgi/test.cgi?arg1=val1&arg2=val2").
+		Form(url.Values{"name": {"Arthur"}}).
+		Cookie(&http.Cookie{Name: "session", Value: "42"}).
+		PathParam("id", "7").
+		Build(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := Record(formHandler(req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "/cgi/test.cgi val1 Arthur 7" {
+		t.Errorf("unexpected response %d %q", resp.StatusCode, body)
+	}
+	if resp.Header.Get("Content-Type") != "text/plain" || resp.ContentLength != int64(len(body)) {
+		t.Errorf("unexpected headers %v", resp.Header)
+	}
+	if cookies := resp.Cookies(); len(cookies) != 1 || cookies[0].Value != "42" {
+		t.Errorf("unexpected cookies %v", cookies)
+	}
+}
+
+func Test_InMemoryServer(t *testing.T) {
+	srv := goscgi.NewServer(goscgi.NewSettings())
+	srv.AddMethodHandler(goscgi.POST, "/users/{id}", formHandler)
+	listener := Start(srv)
+	defer srv.Shutdown(context.Background())
+
+	tests := []struct {
+		req    *RequestBuilder
+		status int
+		body   string
+	}{
+		{NewRequest("POST", "/users/9?arg1=a").Form(url.Values{"name": {"Ford"}}), http.StatusOK, "/users/9 a Ford 9"},
+		{NewRequest("GET", "/users/9"), http.StatusMethodNotAllowed, ""},
+		{NewRequest("GET", "/missing"), http.StatusNotFound, ""},
+		{NewRequest("BREW", "/users/9"), http.StatusMethodNotAllowed, ""},
+		{NewRequest("B@D", "/users/9"), http.StatusNotImplemented, ""},
+	}
+	for _, test := range tests {
+		resp, body, err := listener.Fetch(test.req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != test.status || (len(test.body) > 0 && string(body) != test.body) {
+			t.Errorf("%s: unexpected response %d %q", test.req.Params()[goscgi.RequestUriKey], resp.StatusCode, body)
+		}
+	}
+}
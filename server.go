@@ -5,144 +5,593 @@
 package goscgi
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"mime/multipart"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"runtime/debug"
 	"strings"
 	"sync"
 	"time"
 )
 
 type Server struct {
-	Settings  *Settings
-	Handlers  []Handler
-	Close     chan bool
+	Settings    *Settings
+	Router      *Router
+	BaseContext context.Context // the parent of all request contexts; nil = context.Background()
+	// UpgradeSignal, if not nil (e.g. syscall.SIGUSR2), makes ListenTcp & ListenUnix call Upgrade
+	// when the process receives it. It must be set before listening.
+	UpgradeSignal os.Signal
+	// ErrorHandler turns the errors returned by the handlers (see HandlerE) & PartFunc into responses;
+	// nil = DefaultErrorHandler.
+	ErrorHandler func(req *Request, err error) *Response
+	// PanicHandler, if not nil, is called with the value & stack trace of the panics
+	// recovered while handling req (e.g. to report them), after they are logged.
+	PanicHandler func(req *Request, value any, stack []byte)
+	// Deprecated: use Shutdown. Sending on or closing Close shuts down the servers
+	// started by ListenTcp & ListenUnix, like os.Interrupt.
+	Close chan bool
+	// Deprecated: use Shutdown, which waits for the running requests.
+	// WaitGroup counts the connections being handled.
 	WaitGroup sync.WaitGroup
+	// Deprecated: use AddHandler. The handlers appended to Handlers match the paths starting
+	// with their Path, in order, when the Router has no pattern for the path.
+	// They are not wrapped in middleware.
+	Handlers []Handler
+
+	fallback HandlerFunc // answers the requests without handler, wrapped in the server middleware
+
+	mutex      sync.Mutex
+	listeners  map[net.Listener]bool
+	conns      map[net.Conn]string // active connections -> request description
+	inShutdown bool
+	upgrading  bool
+	idle       chan struct{}      // closed when no connections are left after Shutdown
+	ctx        context.Context    // the parent of the request contexts
+	cancel     context.CancelFunc // cancels ctx when Shutdown gives up waiting
 }
 
+var (
+	ServerClosedErr = errors.New("Server closed")
+	NilResponseErr  = errors.New("Handler returned a nil response")
+)
+
 type Handler struct {
-	Path string
-	Func HandlerFunc
+	Path    string
+	Method  string // "" = any method
+	Func    HandlerFunc
+	Parts   PartFunc      // if not nil, receives the multipart/form-data parts instead of req.MultipartForm
+	Timeout time.Duration // overrides Settings.HandlerTimeout
+
+	Middleware []Middleware // see WithMiddleware
+
+	group   *Group
+	chained HandlerFunc // Func wrapped in all the middleware that applies to it
 }
 
 type HandlerFunc func(*Request) *Response
 
+// HandlerFuncE is a handler returning an error instead of an error response, see HandlerE.
+type HandlerFuncE func(*Request) (*Response, error)
+
+// HandlerE adapts fn to a HandlerFunc, so it can be added to a Router or Group:
+// the errors returned by fn are turned into responses by Server.ErrorHandler.
+func HandlerE(fn HandlerFuncE) HandlerFunc {
+	return func(req *Request) *Response {
+		resp, err := fn(req)
+		if err != nil {
+			return req.srv.handleError(req, err)
+		}
+		if resp == nil {
+			return req.srv.handleError(req, NilResponseErr)
+		}
+		return resp
+	}
+}
+
+// HandlerOption sets optional Handler fields in AddHandler & AddMethodHandler.
+type HandlerOption func(*Handler)
+
+// WithParts streams the multipart/form-data parts of the requests to parts,
+// as they arrive, instead of parsing them in req.MultipartForm.
+func WithParts(parts PartFunc) HandlerOption {
+	return func(handler *Handler) {
+		handler.Parts = parts
+	}
+}
+
+// WithTimeout sets the deadline of the request context, overriding Settings.HandlerTimeout.
+func WithTimeout(timeout time.Duration) HandlerOption {
+	return func(handler *Handler) {
+		handler.Timeout = timeout
+	}
+}
+
 var (
-	RespNotFound      = NewResponse(RespCodeNotFound, RespTypeText, RespCodeNotFound)
-	RespBadRequest    = NewResponse(RespCodeBadRequest, RespTypeText, RespCodeBadRequest)
-	RespInternalError = NewResponse(RespCodeInternalError, RespTypeText, RespCodeInternalError)
+	RespNotFound        = NewResponse(RespCodeNotFound, RespTypeText, RespCodeNotFound)
+	RespBadRequest      = NewResponse(RespCodeBadRequest, RespTypeText, RespCodeBadRequest)
+	RespRequestTimeout  = NewResponse(RespCodeRequestTimeout, RespTypeText, RespCodeRequestTimeout)
+	RespContentTooLarge = NewResponse(RespCodeContentTooLarge, RespTypeText, RespCodeContentTooLarge)
+	RespHeaderTooLarge  = NewResponse(RespCodeHeaderTooLarge, RespTypeText, RespCodeHeaderTooLarge)
+	RespNotImplemented  = NewResponse(RespCodeNotImplemented, RespTypeText, RespCodeNotImplemented)
+	RespInternalError   = NewResponse(RespCodeInternalError, RespTypeText, RespCodeInternalError)
 )
 
 func NewServer(s *Settings) *Server {
 	srv := &Server{}
 	srv.Settings = s
+	srv.Router = NewRouter()
+	srv.fallback = fallback
 	srv.Close = make(chan bool)
+	srv.listeners = map[net.Listener]bool{}
+	srv.conns = map[net.Conn]string{}
 	return srv
 }
 
-func (srv *Server) AddHandler(path string, handler HandlerFunc) {
-	srv.Handlers = append(srv.Handlers, Handler{path, handler})
+// AddHandler adds a handler for the requests matching path (see Router), whatever their method.
+func (srv *Server) AddHandler(path string, handler HandlerFunc, options ...HandlerOption) {
+	srv.Router.AddHandler(path, handler, options...)
+}
+
+// AddMethodHandler adds a handler for the requests matching path and method.
+func (srv *Server) AddMethodHandler(method, path string, handler HandlerFunc, options ...HandlerOption) {
+	srv.Router.AddMethodHandler(method, path, handler, options...)
+}
+
+// Group creates a handler group for the paths under prefix (see Router.Group).
+func (srv *Server) Group(prefix string, middleware ...Middleware) *Group {
+	return srv.Router.Group(prefix, middleware...)
 }
 
+// Use adds middleware that wraps every request (see Middleware).
+// It must be called before the server starts listening.
+func (srv *Server) Use(middleware ...Middleware) {
+	srv.Router.use(middleware)
+	srv.fallback = wrap(fallback, srv.Router.middleware)
+}
+
+// ListenTcp listens on the TCP address port & serves the requests until the server is shut down,
+// either by calling Shutdown or by an os.Interrupt signal.
+// If the process inherited a listener bound to the same address (see InheritedListeners),
+// that listener is used instead of binding a new one.
+// It always returns a non-nil error; after Shutdown the error is ServerClosedErr.
 func (srv *Server) ListenTcp(port string) error {
 	addr, err := net.ResolveTCPAddr("tcp", port)
 	if err != nil {
 		return err
 	}
+	if listener := inheritedTcpListener(addr); listener != nil {
+		return srv.serveUntilInterrupt(listener)
+	}
 	listener, err := net.ListenTCP("tcp", addr)
 	if err != nil {
 		return err
 	}
-	defer listener.Close()
-	defer srv.WaitGroup.Wait()
-	srv.listenLoop(listener)
-	return nil
+	return srv.serveUntilInterrupt(listener)
 }
 
+// ListenUnix is like ListenTcp, but for the unix socket at addrStr.
 func (srv *Server) ListenUnix(addrStr string) error {
 	addr, err := net.ResolveUnixAddr("unix", addrStr)
 	if err != nil {
 		return err
 	}
+	if listener := inheritedUnixListener(addr); listener != nil {
+		return srv.serveUntilInterrupt(listener)
+	}
 	listener, err := net.ListenUnix("unix", addr)
 	if err != nil {
 		return err
 	}
-	defer listener.Close()
-	defer srv.WaitGroup.Wait()
-	srv.listenLoop(listener)
-	return nil
+	return srv.serveUntilInterrupt(listener)
 }
 
-type anyListener interface {
-	Accept() (net.Conn, error)
-	SetDeadline(time.Time) error
+// serveUntilInterrupt serves on listener & shuts down the server on os.Interrupt
+// (or on the deprecated Close channel), giving the running handlers Settings.ShutdownTimeout to finish.
+// On UpgradeSignal, it upgrades the process (see Upgrade).
+func (srv *Server) serveUntilInterrupt(listener net.Listener) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	if srv.UpgradeSignal != nil {
+		signal.Notify(signals, srv.UpgradeSignal)
+	}
+	defer signal.Stop(signals)
+	served := make(chan bool)
+	shutdownErr := make(chan error, 1)
+	go func() {
+		for {
+			select {
+			case <-srv.Close:
+				log.Println("Server, <-srv.Close: shutting down...")
+				shutdownErr <- srv.shutdownWithTimeout()
+				return
+			case sig := <-signals:
+				var err error
+				if sig == os.Interrupt {
+					log.Println("Server, os.Interrupt: shutting down...")
+					err = srv.shutdownWithTimeout()
+				} else {
+					log.Println("Server,", sig.String()+": upgrading...")
+					if err = srv.Upgrade(); err != nil && !srv.shuttingDown() {
+						log.Println("Server.Upgrade:", err.Error())
+						continue // the old process keeps serving
+					}
+				}
+				shutdownErr <- err
+				return
+			case <-served:
+				shutdownErr <- nil
+				return
+			}
+		}
+	}()
+	err := srv.Serve(listener)
+	close(served)
+	// if interrupted, wait for the shutdown to finish
+	if err := <-shutdownErr; err != nil {
+		log.Println("Server.Shutdown:", err.Error())
+	}
+	return err
 }
 
-func (srv *Server) listenLoop(listener anyListener) {
-	osInterrupt := make(chan os.Signal, 1)
-	signal.Notify(osInterrupt, os.Interrupt)
-	listenTimeout := srv.Settings.ListenTimeout
+// Serve accepts connections on listener & handles their requests until
+// the listener fails or the server is shut down; it closes the listener on return.
+// Serve may be called concurrently with several listeners (e.g. a TCP and a unix socket),
+// Shutdown stops all of them and waits for the requests received on any of them.
+// It always returns a non-nil error; after Shutdown the error is ServerClosedErr.
+func (srv *Server) Serve(listener net.Listener) error {
+	defer listener.Close()
+	ctx, ok := srv.trackListener(listener, true)
+	if !ok {
+		return ServerClosedErr
+	}
+	defer srv.trackListener(listener, false)
+	notifyUpgradeReady()
+	var retryDelay time.Duration
 	for {
-		select {
-		case <-srv.Close:
-			log.Println("Server.listenLoop, <-srv.Close: closing...")
-			return
-		case <-osInterrupt:
-			log.Println("Server.listenLoop, <-osInterrupt: terminating...")
-			return
-		default: // if default -> go and execute the rest of the loop
+		conn, err := listener.Accept()
+		if err != nil {
+			if srv.shuttingDown() {
+				return ServerClosedErr
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Temporary() {
+				// e.g. too many open files: wait a bit for some connections to close
+				if retryDelay = 2 * retryDelay; retryDelay == 0 {
+					retryDelay = 5 * time.Millisecond
+				} else if retryDelay > time.Second {
+					retryDelay = time.Second
+				}
+				log.Println("Server.Serve, listener.Accept:", err.Error())
+				time.Sleep(retryDelay)
+				continue
+			}
+			return err
+		}
+		retryDelay = 0
+		if !srv.trackConn(conn, true) {
+			conn.Close()
+			continue
 		}
+		go srv.handleConn(ctx, conn)
+	}
+}
 
-		listener.SetDeadline(time.Now().Add(listenTimeout))
-		conn, err := listener.Accept()
-		if err == nil {
-			srv.WaitGroup.Add(1)
-			go srv.handleConn(conn)
-		} /* else {
-			log.Println("Server.listenLoop, listener.Accept():", err.Error())
-		}*/
+// Shutdown gracefully shuts down the server: it closes the listeners right away,
+// then waits for the running requests to finish. If ctx expires first, the request
+// contexts are cancelled, the remaining connections are closed and the returned
+// error describes the abandoned requests.
+// Once Shutdown was called, the server can't be used for serving again.
+func (srv *Server) Shutdown(ctx context.Context) error {
+	srv.mutex.Lock()
+	srv.inShutdown = true
+	for listener := range srv.listeners {
+		listener.Close()
+	}
+	if srv.idle == nil {
+		srv.idle = make(chan struct{})
+		if len(srv.conns) == 0 {
+			close(srv.idle)
+		}
+	}
+	idle := srv.idle
+	srv.mutex.Unlock()
+
+	select {
+	case <-idle:
+		srv.cancelRequests()
+		return nil
+	case <-ctx.Done():
+	}
+
+	srv.cancelRequests()
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	if len(srv.conns) == 0 {
+		return nil // just finished
 	}
+	abandoned := make([]string, 0, len(srv.conns))
+	for conn, desc := range srv.conns {
+		conn.Close()
+		abandoned = append(abandoned, desc)
+	}
+	return fmt.Errorf("goscgi: shutdown: %w, closed %d connection(s): %s", ctx.Err(), len(abandoned), strings.Join(abandoned, "; "))
 }
 
-func (srv *Server) handleConn(conn net.Conn) {
-	defer srv.WaitGroup.Done()
+// used when Settings.ShutdownTimeout is 0
+const defaultShutdownTimeout = 10 * time.Second
+
+// shutdownWithTimeout shuts down the server, waiting at most Settings.ShutdownTimeout (0 = 10 seconds).
+func (srv *Server) shutdownWithTimeout() error {
+	timeout := srv.Settings.ShutdownTimeout
+	if timeout == 0 {
+		timeout = defaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return srv.Shutdown(ctx)
+}
+
+// trackListener adds or removes an active listener; it returns
+// the parent of the request contexts & false if the server was shut down.
+func (srv *Server) trackListener(listener net.Listener, add bool) (context.Context, bool) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	if !add {
+		delete(srv.listeners, listener)
+		return nil, true
+	}
+	if srv.inShutdown {
+		return nil, false
+	}
+	if srv.ctx == nil {
+		baseCtx := srv.BaseContext
+		if baseCtx == nil {
+			baseCtx = context.Background()
+		}
+		srv.ctx, srv.cancel = context.WithCancel(baseCtx)
+	}
+	srv.listeners[listener] = true
+	return srv.ctx, true
+}
+
+// trackConn adds or removes an active connection; it returns false if the server was shut down.
+func (srv *Server) trackConn(conn net.Conn, add bool) bool {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	if !add {
+		if _, ok := srv.conns[conn]; ok {
+			delete(srv.conns, conn)
+			srv.WaitGroup.Done()
+		}
+		if srv.idle != nil && len(srv.conns) == 0 {
+			close(srv.idle)
+		}
+		return true
+	}
+	if srv.inShutdown {
+		return false
+	}
+	srv.conns[conn] = "reading request from " + conn.RemoteAddr().String()
+	srv.WaitGroup.Add(1)
+	return true
+}
+
+// describeConn sets the connection description used in the Shutdown error.
+func (srv *Server) describeConn(conn net.Conn, desc string) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	if _, ok := srv.conns[conn]; ok {
+		srv.conns[conn] = desc
+	}
+}
+
+func (srv *Server) shuttingDown() bool {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	return srv.inShutdown
+}
+
+func (srv *Server) cancelRequests() {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	if srv.cancel != nil {
+		srv.cancel()
+	}
+}
+
+func (srv *Server) handleConn(ctx context.Context, conn net.Conn) {
+	defer srv.trackConn(conn, false)
 	defer conn.Close()
-	req, err := ReadRequest(conn, srv.Settings)
+	var req *Request
+	defer func() {
+		// the panics of Response.Stream, those of the handlers, middleware & PartFunc are recovered by callHandler
+		if value := recover(); value != nil {
+			if value == http.ErrAbortHandler {
+				return // abort the response, the connection is closed
+			}
+			if req == nil {
+				log.Printf("Server.handleConn, panic: %v\n%s", value, debug.Stack())
+				return
+			}
+			srv.recovered(req, value, debug.Stack())
+			if !req.wroteResponse {
+				srv.writeResponse(req, newErrorResponse(http.StatusInternalServerError, "", req.Header.Get(HttpAcceptKey)))
+			}
+		}
+		if req != nil {
+			drainContent(req.Body)
+		}
+	}()
+	req, err := readRequest(conn, srv.Settings)
 	if err != nil {
-		log.Println("Server.handleConn, ReadRequest:", err.Error())
-		err = RespBadRequest.Write(conn, srv.Settings.WriteTimeout)
-		if err != nil {
-			log.Println("Server.handleConn, RespBadRequest.Send:", err.Error())
+		srv.writeError(conn, req, "readRequest", err)
+		return
+	}
+	req.srv = srv
+	srv.describeConn(conn, req.Method+" "+req.RawURI)
+	handler, params, allowed := srv.Router.lookup(req.Method, req.URL.Path)
+	if handler == nil && len(allowed) == 0 {
+		handler = srv.prefixHandler(req.URL.Path)
+	}
+	req.Params = params
+	req.allowed = allowed
+	var cancel context.CancelFunc
+	req.ctx, cancel = srv.newRequestContext(ctx, handler)
+	defer cancel()
+	req.forms = &[]*multipart.Form{}
+	defer func() {
+		for _, form := range *req.forms {
+			form.RemoveAll()
 		}
-	} else {
-		srv.handleReq(req)
+	}()
+	watchPeer(req, cancel)
+	srv.handleReq(req, handler)
+}
+
+// writeError answers a request that couldn't be read with the status matching err
+// (see ErrorKind); if the peer is gone, the connection is just closed.
+// req is nil if the request header couldn't be read.
+func (srv *Server) writeError(conn net.Conn, req *Request, call string, err error) {
+	var accept string
+	if req != nil {
+		accept = req.Header.Get(HttpAcceptKey)
+	}
+	resp := errorResponse(err, accept)
+	if resp == nil {
+		return
+	}
+	log.Println("Server.handleConn, "+call+":", err.Error())
+	if err = resp.Write(conn, srv.Settings.WriteTimeout); err != nil {
+		log.Println("Server.handleConn, errorResponse.Write:", err.Error())
 	}
 }
 
-func (srv *Server) handleReq(req *Request) {
-	var err error
-	timeout := srv.Settings.WriteTimeout
-	if handler := srv.getHandler(req.URL.Path); handler != nil {
-		if resp := handler(req); resp != nil {
-			err = resp.Write(req.Connection, timeout)
-		} else {
-			err = RespInternalError.Write(req.Connection, timeout)
-		}
-	} else {
-		err = RespNotFound.Write(req.Connection, timeout)
+func (srv *Server) handleReq(req *Request, handler *Handler) {
+	handlerFunc := srv.fallback
+	if handler != nil {
+		handlerFunc = handler.chained
+	}
+	resp := srv.callHandler(handlerFunc, req)
+	if resp == nil {
+		resp = srv.handleError(req, NilResponseErr)
+	}
+	srv.writeResponse(req, resp)
+}
+
+func (srv *Server) writeResponse(req *Request, resp *Response) {
+	req.wroteResponse = true
+	err := resp.write(req.Connection, srv.Settings.WriteTimeout, req.Method == HEAD)
+	if err == InvalidStatusErr {
+		log.Println("Server.handleReq:", req.Method, req.RawURI, err.Error())
+		err = RespInternalError.write(req.Connection, srv.Settings.WriteTimeout, req.Method == HEAD)
 	}
 	if err != nil {
 		log.Println("Server.handleReq:", err.Error())
 	}
 }
 
-func (srv *Server) getHandler(path string) HandlerFunc {
+// prefixHandler returns the first of the deprecated Handlers whose Path is a prefix of path.
+func (srv *Server) prefixHandler(path string) *Handler {
 	for _, handler := range srv.Handlers {
-		if strings.HasPrefix(path, handler.Path) {
-			return handler.Func
+		if strings.HasPrefix(path, handler.Path) && handler.Func != nil {
+			handler.chained = withContent(&handler)
+			return &handler
 		}
 	}
 	return nil
 }
+
+// withContent wraps the handler func with the parsing of the request content (see Request.parseContent),
+// so the content is read only if the middleware calls the handler. The errors of the parts func
+// are answered by ErrorHandler, the protocol errors like in writeError.
+func withContent(handler *Handler) HandlerFunc {
+	handlerFunc, parts := handler.Func, handler.Parts
+	return func(req *Request) *Response {
+		err := req.parseContent(parts)
+		if err == nil {
+			return handlerFunc(req)
+		}
+		var protocolErr *ProtocolError
+		if !errors.As(err, &protocolErr) {
+			return req.srv.handleError(req, err) // returned by parts
+		}
+		resp := errorResponse(err, req.Header.Get(HttpAcceptKey))
+		if resp == nil {
+			panic(http.ErrAbortHandler) // the peer is gone, no response
+		}
+		log.Println("Server.handleConn, parseContent:", err.Error())
+		return resp
+	}
+}
+
+// fallback answers the requests without handler: with the allowed methods
+// to OPTIONS, with 405 if other methods are allowed, with 404 otherwise.
+func fallback(req *Request) *Response {
+	if len(req.allowed) > 0 && req.Method == OPTIONS {
+		return newOptions(req.allowed)
+	} else if len(req.allowed) > 0 {
+		return newMethodNotAllowed(req, req.allowed)
+	}
+	return newNotFound(req)
+}
+
+// newOptions answers OPTIONS requests for the paths without an OPTIONS handler.
+func newOptions(allowed []string) *Response {
+	resp := NewResponse(RespCodeOK, RespTypeText, nil)
+	resp.Header.Set("Allow", strings.Join(allowed, ", "))
+	return resp
+}
+
+// callHandler calls handlerFunc; if it panics with a *Problem, the problem is passed to ErrorHandler,
+// http.ErrAbortHandler aborts the response (see handleConn), other panics are logged
+// (see recovered) and answered with 500.
+func (srv *Server) callHandler(handlerFunc HandlerFunc, req *Request) (resp *Response) {
+	defer func() {
+		if value := recover(); value != nil {
+			if problem, ok := value.(*Problem); ok {
+				resp = srv.handleError(req, problem)
+				return
+			} else if value == http.ErrAbortHandler {
+				panic(value)
+			}
+			srv.recovered(req, value, debug.Stack())
+			resp = newErrorResponse(http.StatusInternalServerError, "", req.Header.Get(HttpAcceptKey))
+		}
+	}()
+	return handlerFunc(req)
+}
+
+// recovered logs a panic recovered while handling req & passes it to PanicHandler.
+func (srv *Server) recovered(req *Request, value any, stack []byte) {
+	log.Printf("Server.handleConn, panic: %v\n%s %s from %s\n%s", value, req.Method, req.RawURI, req.Header.Get(RemoteAddrKey), stack)
+	if srv.PanicHandler != nil {
+		callPanicHandler("Server.PanicHandler", func() { srv.PanicHandler(req, value, stack) })
+	}
+}
+
+// callPanicHandler calls a PanicHandler; its own panics are only logged,
+// they would otherwise be recovered & reported again, endlessly.
+func callPanicHandler(name string, call func()) {
+	defer func() {
+		if value := recover(); value != nil {
+			log.Printf("%s, panic: %v\n%s", name, value, debug.Stack())
+		}
+	}()
+	call()
+}
+
+func newNotFound(req *Request) *Response {
+	return newErrorResponse(http.StatusNotFound, "", req.Header.Get(HttpAcceptKey))
+}
+
+func newMethodNotAllowed(req *Request, allowed []string) *Response {
+	resp := newErrorResponse(http.StatusMethodNotAllowed, "", req.Header.Get(HttpAcceptKey))
+	resp.Header.Set("Allow", strings.Join(allowed, ", "))
+	return resp
+}
@@ -0,0 +1,333 @@
+// Copyright 2013 Liviu G. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package goscgi_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"goscgi"
+	"goscgi/scgitest"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func Test_Methods(t *testing.T) {
+	srv := goscgi.NewServer(goscgi.NewSettings())
+	srv.AddMethodHandler(goscgi.GET, "/page", func(req *goscgi.Request) *goscgi.Response {
+		return goscgi.NewResponse(goscgi.RespCodeOK, goscgi.RespTypeText, []byte(req.Method+" page"))
+	})
+	srv.AddMethodHandler(goscgi.PATCH, "/page", func(req *goscgi.Request) *goscgi.Response {
+		return goscgi.NewResponse(goscgi.RespCodeOK, goscgi.RespTypeText, []byte("patched"))
+	})
+	srv.AddMethodHandler("PROPFIND", "/dav/", func(req *goscgi.Request) *goscgi.Response {
+		return goscgi.NewResponse(goscgi.RespCodeOK, goscgi.RespTypeText, []byte("dav"))
+	})
+	listener := scgitest.Start(srv)
+	defer srv.Shutdown(context.Background())
+
+	// raw sends the request & returns the whole response, as sent by the server
+	raw := func(b *scgitest.RequestBuilder) string {
+		conn, err := listener.Dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		go conn.Write(b.Bytes())
+		resp, _ := io.ReadAll(conn)
+		return string(resp)
+	}
+	tests := []struct {
+		method, path, expected string
+	}{
+		{"GET", "/page", "Status: 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 8\r\n\r\nGET page"},
+		{"HEAD", "/page", "Status: 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 9\r\n\r\n"},
+		{"PATCH", "/page", "Status: 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 7\r\n\r\npatched"},
+		{"OPTIONS", "/page", "Status: 200 OK\r\nContent-Type: text/plain\r\nAllow: GET, HEAD, OPTIONS, PATCH\r\n\r\n"},
+		{"PROPFIND", "/dav/a/b", "Status: 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 3\r\n\r\ndav"},
+		{"DELETE", "/dav/a/b", "Status: 405 Method Not Allowed\r\nContent-Type: text/plain\r\nContent-Length: 22\r\n" +
+			"Allow: OPTIONS, PROPFIND\r\n\r\n405 Method Not Allowed"},
+	}
+	for _, test := range tests {
+		if resp := raw(scgitest.NewRequest(test.method, test.path)); resp != test.expected {
+			t.Errorf("%s %s: expected %q, got %q", test.method, test.path, test.expected, resp)
+		}
+	}
+}
+
+type teapotErr struct{}
+
+func (teapotErr) Error() string   { return "no coffee here" }
+func (teapotErr) StatusCode() int { return http.StatusTeapot }
+
+func Test_ErrorHandler(t *testing.T) {
+	srv := goscgi.NewServer(goscgi.NewSettings())
+	srv.AddHandler("/teapot", goscgi.HandlerE(func(req *goscgi.Request) (*goscgi.Response, error) {
+		return nil, fmt.Errorf("brewing: %w", teapotErr{})
+	}))
+	srv.AddHandler("/secret", goscgi.HandlerE(func(req *goscgi.Request) (*goscgi.Response, error) {
+		return nil, errors.New("database password rejected")
+	}))
+	srv.AddHandler("/nil", func(req *goscgi.Request) *goscgi.Response {
+		return nil
+	})
+	srv.AddHandler("/ok", goscgi.HandlerE(func(req *goscgi.Request) (*goscgi.Response, error) {
+		return goscgi.NewResponse(goscgi.RespCodeOK, goscgi.RespTypeText, []byte("ok")), nil
+	}))
+	srv.AddHandler("/unavailable", goscgi.HandlerE(func(req *goscgi.Request) (*goscgi.Response, error) {
+		return nil, goscgi.NewProblem(http.StatusServiceUnavailable, "replica 10.0.0.3 is down")
+	}))
+	srv.AddHandler("/upload", func(req *goscgi.Request) *goscgi.Response {
+		return goscgi.NewResponse(goscgi.RespCodeOK, goscgi.RespTypeText, nil)
+	}, goscgi.WithParts(func(req *goscgi.Request, part *multipart.Part) error {
+		return goscgi.NewProblem(http.StatusInsufficientStorage, "disk full")
+	}))
+	srv.AddHandler("/panic", func(req *goscgi.Request) *goscgi.Response {
+		panic(goscgi.NewProblem(http.StatusServiceUnavailable, "replica 10.0.0.3 is down"))
+	})
+	srv.AddHandler("/garbage", func(req *goscgi.Request) *goscgi.Response {
+		return goscgi.NewResponse(goscgi.RespCodeOK, goscgi.RespTypeText, nil)
+	}, goscgi.WithParts(func(req *goscgi.Request, part *multipart.Part) error {
+		return nil
+	}))
+	listener := scgitest.Start(srv)
+
+	var form bytes.Buffer
+	formWriter := multipart.NewWriter(&form)
+	formWriter.WriteField("name", "Arthur")
+	formWriter.Close()
+	defer srv.Shutdown(context.Background())
+
+	tests := []struct {
+		path, accept string
+		status       int
+		expected     string
+	}{
+		{"/teapot", "", http.StatusTeapot, "418 I'm a teapot"},
+		{"/teapot", "application/json", http.StatusTeapot,
+			`{"type":"about:blank","title":"I'm a teapot","status":418,"detail":"brewing: no coffee here"}`},
+		{"/secret", "application/json", http.StatusInternalServerError, `{"type":"about:blank","title":"Internal Server Error","status":500}`},
+		{"/nil", "", http.StatusInternalServerError, "500 Internal Server Error"},
+		{"/ok", "", http.StatusOK, "ok"},
+		{"/unavailable", "", http.StatusServiceUnavailable, `{"type":"about:blank","title":"Service Unavailable","status":503}`},
+		{"/upload", "", http.StatusInsufficientStorage, `{"type":"about:blank","title":"Insufficient Storage","status":507}`},
+		{"/panic", "", http.StatusServiceUnavailable, `{"type":"about:blank","title":"Service Unavailable","status":503}`},
+		{"/garbage", "", http.StatusBadRequest, "400 Bad Request"}, // a malformed multipart content isn't a PartFunc error
+	}
+	check := func() {
+		for _, test := range tests {
+			req := scgitest.NewRequest("GET", test.path).Header("Accept", test.accept)
+			if test.path == "/upload" {
+				req = scgitest.NewRequest("POST", test.path).Content(formWriter.FormDataContentType(), form.Bytes())
+			} else if test.path == "/garbage" {
+				req = scgitest.NewRequest("POST", test.path).Content("multipart/form-data; boundary=xyz", []byte("garbage"))
+			}
+			resp, body, err := listener.Fetch(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != test.status || string(body) != test.expected {
+				t.Errorf("%s: unexpected response %d %q", test.path, resp.StatusCode, body)
+			}
+		}
+	}
+	check()
+
+	var handled []string
+	srv.ErrorHandler = func(req *goscgi.Request, err error) *goscgi.Response {
+		handled = append(handled, req.URL.Path+": "+err.Error())
+		return goscgi.NewStatusResponse(http.StatusServiceUnavailable, goscgi.RespTypeText, []byte("later"))
+	}
+	tests = append(tests[2:4], tests[6:9]...)
+	for idx := range tests[:4] {
+		tests[idx].status, tests[idx].expected = http.StatusServiceUnavailable, "later"
+	}
+	check()
+	if strings.Join(handled, ", ") != "/secret: database password rejected, /nil: Handler returned a nil response, "+
+		"/upload: Insufficient Storage: disk full, /panic: Service Unavailable: replica 10.0.0.3 is down" {
+		t.Errorf("unexpected errors %q", handled)
+	}
+}
+
+func Test_PanicRecovery(t *testing.T) {
+	srv := goscgi.NewServer(goscgi.NewSettings())
+	srv.AddHandler("/handler", func(req *goscgi.Request) *goscgi.Response {
+		panic("handler boom")
+	})
+	srv.AddHandler("/stream", func(req *goscgi.Request) *goscgi.Response {
+		return goscgi.NewStreamResponse(goscgi.RespCodeOK, goscgi.RespTypeText, func(w *goscgi.ResponseWriter) error {
+			w.WriteString("partial")
+			w.Flush()
+			panic("stream boom")
+		})
+	})
+	srv.AddHandler("/upload", func(req *goscgi.Request) *goscgi.Response {
+		return goscgi.NewResponse(goscgi.RespCodeOK, goscgi.RespTypeText, nil)
+	}, goscgi.WithParts(func(req *goscgi.Request, part *multipart.Part) error {
+		panic("parts boom")
+	}))
+	srv.AddHandler("/abort", func(req *goscgi.Request) *goscgi.Response {
+		panic(http.ErrAbortHandler)
+	})
+	var mutex sync.Mutex
+	var panics []string
+	srv.PanicHandler = func(req *goscgi.Request, value any, stack []byte) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if !strings.Contains(string(stack), "Test_PanicRecovery") {
+			t.Errorf("the stack of %v doesn't show the panic origin:\n%s", value, stack)
+		}
+		panics = append(panics, req.URL.Path+" "+fmt.Sprint(value))
+	}
+	listener := scgitest.Start(srv)
+	defer srv.Shutdown(context.Background())
+
+	var form bytes.Buffer
+	formWriter := multipart.NewWriter(&form)
+	formWriter.WriteField("name", "Arthur")
+	formWriter.Close()
+	tests := []struct {
+		req      *scgitest.RequestBuilder
+		status   int
+		expected string
+	}{
+		{scgitest.NewRequest("GET", "/handler"), http.StatusInternalServerError, "500 Internal Server Error"},
+		{scgitest.NewRequest("GET", "/stream"), http.StatusOK, "partial"},
+		{scgitest.NewRequest("POST", "/upload").Content(formWriter.FormDataContentType(), form.Bytes()),
+			http.StatusInternalServerError, "500 Internal Server Error"},
+	}
+	for _, test := range tests {
+		resp, body, err := listener.Fetch(test.req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != test.status || string(body) != test.expected {
+			t.Errorf("%s: unexpected response %d %q", test.req.Params()[goscgi.RequestUriKey], resp.StatusCode, body)
+		}
+	}
+	// http.ErrAbortHandler closes the connection without response & isn't reported
+	if resp, _, err := listener.Fetch(scgitest.NewRequest("GET", "/abort")); err == nil {
+		t.Errorf("expected no response to /abort, got %d", resp.StatusCode)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if strings.Join(panics, ", ") != "/handler handler boom, /stream stream boom, /upload parts boom" {
+		t.Errorf("unexpected panics %q", panics)
+	}
+}
+
+func Test_PanicHandlerPanic(t *testing.T) {
+	srv := goscgi.NewServer(goscgi.NewSettings())
+	srv.AddHandler("/handler", func(req *goscgi.Request) *goscgi.Response {
+		panic("handler boom")
+	})
+	srv.AddHandler("/upload", func(req *goscgi.Request) *goscgi.Response {
+		return goscgi.NewResponse(goscgi.RespCodeOK, goscgi.RespTypeText, nil)
+	}, goscgi.WithParts(func(req *goscgi.Request, part *multipart.Part) error {
+		panic("parts boom")
+	}))
+	srv.PanicHandler = func(req *goscgi.Request, value any, stack []byte) {
+		panic("tracker down")
+	}
+	listener := scgitest.Start(srv)
+	defer srv.Shutdown(context.Background())
+
+	var form bytes.Buffer
+	formWriter := multipart.NewWriter(&form)
+	formWriter.WriteField("name", "Arthur")
+	formWriter.Close()
+	for _, req := range []*scgitest.RequestBuilder{
+		scgitest.NewRequest("GET", "/handler"),
+		scgitest.NewRequest("POST", "/upload").Content(formWriter.FormDataContentType(), form.Bytes()),
+	} {
+		resp, _, err := listener.Fetch(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusInternalServerError {
+			t.Errorf("%s: expected 500, got %d", req.Params()[goscgi.RequestUriKey], resp.StatusCode)
+		}
+	}
+}
+
+func Test_DeprecatedHandlers(t *testing.T) {
+	srv := goscgi.NewServer(goscgi.NewSettings())
+	srv.AddHandler("/ajax", func(req *goscgi.Request) *goscgi.Response {
+		return goscgi.NewResponse(goscgi.RespCodeOK, goscgi.RespTypeText, []byte("router"))
+	})
+	srv.Handlers = append(srv.Handlers, goscgi.Handler{Path: "/ajax", Func: func(req *goscgi.Request) *goscgi.Response {
+		return goscgi.NewResponse(goscgi.RespCodeOK, goscgi.RespTypeText, []byte("prefix"))
+	}})
+	listener := scgitest.Start(srv)
+	defer srv.Shutdown(context.Background())
+
+	tests := []struct {
+		path     string
+		status   int
+		expected string
+	}{
+		{"/ajax", http.StatusOK, "router"},
+		{"/ajax/x", http.StatusOK, "prefix"},
+		{"/ajaxy", http.StatusOK, "prefix"},
+		{"/other", http.StatusNotFound, "404 Not Found"},
+	}
+	for _, test := range tests {
+		resp, body, err := listener.Fetch(scgitest.NewRequest("GET", test.path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != test.status || string(body) != test.expected {
+			t.Errorf("%s: unexpected response %d %q", test.path, resp.StatusCode, body)
+		}
+	}
+}
+
+func Test_MiddlewareBeforeParts(t *testing.T) {
+	srv := goscgi.NewServer(goscgi.NewSettings())
+	srv.Use(func(next goscgi.HandlerFunc) goscgi.HandlerFunc {
+		return func(req *goscgi.Request) *goscgi.Response {
+			if req.HTTPHeader().Get("X-Token") != "secret" {
+				return goscgi.NewStatusResponse(http.StatusUnauthorized, goscgi.RespTypeText, nil)
+			}
+			return next(req)
+		}
+	})
+	var mutex sync.Mutex
+	var received []string
+	srv.AddHandler("/upload", func(req *goscgi.Request) *goscgi.Response {
+		return goscgi.NewResponse(goscgi.RespCodeOK, goscgi.RespTypeText, nil)
+	}, goscgi.WithParts(func(req *goscgi.Request, part *multipart.Part) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		received = append(received, part.FormName())
+		return nil
+	}))
+	listener := scgitest.Start(srv)
+	defer srv.Shutdown(context.Background())
+
+	var form bytes.Buffer
+	formWriter := multipart.NewWriter(&form)
+	formWriter.WriteField("name", "Arthur")
+	formWriter.Close()
+	for _, token := range []string{"", "secret"} {
+		req := scgitest.NewRequest("POST", "/upload").Header("X-Token", token).Content(formWriter.FormDataContentType(), form.Bytes())
+		resp, _, err := listener.Fetch(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mutex.Lock()
+		if token == "" && (resp.StatusCode != http.StatusUnauthorized || len(received) > 0) {
+			t.Errorf("the middleware should reject the upload before PartFunc, got %d & parts %q", resp.StatusCode, received)
+		} else if token != "" && (resp.StatusCode != http.StatusOK || strings.Join(received, ",") != "name") {
+			t.Errorf("expected 200 & the part name, got %d & parts %q", resp.StatusCode, received)
+		}
+		mutex.Unlock()
+	}
+}
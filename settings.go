@@ -9,17 +9,30 @@ import "time"
 type Settings struct {
 	MaxHeaderSize  int
 	MaxContentSize int64
-	ListenTimeout  time.Duration
-	ReadTimeout    time.Duration
-	WriteTimeout   time.Duration
+	// Deprecated: ListenTimeout is not used, Shutdown interrupts the blocked Accept calls.
+	ListenTimeout   time.Duration
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	BufferContent   bool
+	HandlerTimeout  time.Duration
+	UpgradeTimeout  time.Duration
+	StrictProtocol  bool
+	ShutdownTimeout time.Duration
+	MaxBodySize     int64
 }
 
 func NewSettings() *Settings {
 	return &Settings{
-		42 * 1024,       //	MaxHeaderSize 42 KB = (max 4KB/cookie) * (max 10 cookies) + 2KB headers
-		4 * 1024 * 1024, //	MaxContentSize 4 MB = the max req.ContentSize accepted; anything over -> bad request
-		3 * time.Second, // ListenTimeout = the max duration listener.Accept() stays blocked waiting for a connection
-		5 * time.Second, // ReadTimeout 5sec * 1MB/sec -> we can receive max 5MB on a 1MB downlink before timeout ?
-		5 * time.Second, // WriteTimeout 5sec * 1MB/sec -> we can deliver max 5MB on a 1MB uplink before timeout ?
+		42 * 1024,        //	MaxHeaderSize 42 KB = (max 4KB/cookie) * (max 10 cookies) + 2KB headers
+		4 * 1024 * 1024,  //	MaxContentSize 4 MB = the max content buffered in memory (forms, BufferContent, JSON); anything over -> 413
+		3 * time.Second,  // ListenTimeout = deprecated, not used anymore
+		5 * time.Second,  // ReadTimeout 5sec * 1MB/sec -> we can receive max 5MB on a 1MB downlink before timeout ?
+		5 * time.Second,  // WriteTimeout 5sec * 1MB/sec -> we can deliver max 5MB on a 1MB uplink before timeout ?
+		false,            // BufferContent = read the whole content in req.Content before calling the handler, instead of streaming it through req.Body
+		0,                // HandlerTimeout = the deadline of req.Context(), can be overridden by WithTimeout; 0 = no deadline
+		30 * time.Second, // UpgradeTimeout = how long Server.Upgrade waits for the new process to start serving; 0 = 30 seconds
+		false,            // StrictProtocol = reject the requests breaking the SCGI protocol (see ProtocolError) instead of tolerating them
+		10 * time.Second, // ShutdownTimeout = how long the running requests may take to finish after os.Interrupt; 0 = 10 seconds
+		0,                // MaxBodySize = the max content streamed through req.Body or to a PartFunc; anything over -> 413 before the handler is called; 0 = unlimited
 	}
 }
@@ -0,0 +1,205 @@
+// Copyright 2013 Liviu G. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package goscgi
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the variables passed to the new process by Upgrade, besides LISTEN_FDS & LISTEN_FDNAMES;
+// LISTEN_PID can't be used because the pid is known only after the process was started
+const (
+	upgradeParentPidKey = "GOSCGI_PARENT_PID"
+	upgradeReadyFdKey   = "GOSCGI_READY_FD"
+	upgradeListenName   = "goscgi" // the name of the listeners that were not inherited
+)
+
+// used when Settings.UpgradeTimeout is 0
+const defaultUpgradeTimeout = 30 * time.Second
+
+var UpgradeInProgressErr = errors.New("Upgrade already in progress")
+
+// upgradeReady is the pipe used by the new process to tell the old one it started serving.
+var upgradeReady struct {
+	once sync.Once
+	file *os.File
+}
+
+type fileListener interface {
+	File() (*os.File, error)
+}
+
+// Upgrade replaces the running process with a new one, without refusing any connection:
+// it starts the current executable (usually a new binary at the same path) with the same
+// arguments, passing it the listening sockets of the server. The new process accepts
+// on them when it calls ListenTcp/ListenUnix with the same address, or ServeInherited.
+// Once the new process serves, this server shuts down gracefully (see Shutdown),
+// giving the running requests Settings.ShutdownTimeout to finish.
+// If the new process doesn't serve in Settings.UpgradeTimeout (0 = 30 seconds),
+// it's killed and this server keeps serving.
+//
+// Upgrade is called automatically by ListenTcp & ListenUnix when the process receives
+// Server.UpgradeSignal.
+func (srv *Server) Upgrade() error {
+	srv.mutex.Lock()
+	if srv.inShutdown {
+		srv.mutex.Unlock()
+		return ServerClosedErr
+	}
+	if srv.upgrading {
+		srv.mutex.Unlock()
+		return UpgradeInProgressErr
+	}
+	srv.upgrading = true
+	var listeners []net.Listener
+	var files []*os.File
+	var names []string
+	var err error
+	for listener := range srv.listeners {
+		var file *os.File
+		if fl, ok := listener.(fileListener); !ok {
+			err = fmt.Errorf("goscgi: can't pass a %T to the new process", listener)
+		} else if file, err = fl.File(); err == nil {
+			listeners = append(listeners, listener)
+			files = append(files, file)
+			names = append(names, inheritedListenerName(listener))
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil && len(files) == 0 {
+		err = errors.New("goscgi: no listener to pass to the new process")
+	}
+	srv.mutex.Unlock()
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	if err == nil {
+		err = srv.startNewProcess(files, names)
+	}
+	if err != nil {
+		srv.mutex.Lock()
+		srv.upgrading = false
+		srv.mutex.Unlock()
+		return err
+	}
+
+	// the unix sockets are still used by the new process
+	for _, listener := range listeners {
+		if unixListener, ok := listener.(*net.UnixListener); ok {
+			unixListener.SetUnlinkOnClose(false)
+		}
+	}
+	return srv.shutdownWithTimeout()
+}
+
+// startNewProcess starts the new process & waits for it to serve.
+func (srv *Server) startNewProcess(files []*os.File, names []string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyReader.Close()
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(files, readyWriter) // fds 3, 4, ...
+	for _, env := range os.Environ() {
+		name, _, _ := strings.Cut(env, "=")
+		switch name {
+		case listenPidKey, listenFdsKey, listenFdNamesKey, upgradeParentPidKey, upgradeReadyFdKey:
+		default:
+			cmd.Env = append(cmd.Env, env)
+		}
+	}
+	cmd.Env = append(cmd.Env,
+		listenFdsKey+"="+strconv.Itoa(len(files)),
+		listenFdNamesKey+"="+strings.Join(names, ":"),
+		upgradeParentPidKey+"="+strconv.Itoa(os.Getpid()),
+		upgradeReadyFdKey+"="+strconv.Itoa(listenFdsStart+len(files)))
+	err = cmd.Start()
+	readyWriter.Close() // only the new process writes in it
+	if err != nil {
+		return err
+	}
+	go cmd.Wait()
+
+	// the read fails if the new process exits without writing
+	ready := make(chan error, 1)
+	go func() {
+		var buff [1]byte
+		_, err := readyReader.Read(buff[:])
+		ready <- err
+	}()
+	timeout := srv.Settings.UpgradeTimeout
+	if timeout == 0 {
+		timeout = defaultUpgradeTimeout
+	}
+	select {
+	case err = <-ready:
+		if err == nil {
+			return nil
+		}
+		err = fmt.Errorf("goscgi: the new process (pid %d) exited before serving: %w", cmd.Process.Pid, err)
+	case <-time.After(timeout):
+		err = fmt.Errorf("goscgi: the new process (pid %d) didn't serve in %v", cmd.Process.Pid, timeout)
+	}
+	cmd.Process.Kill()
+	return err
+}
+
+// loadUpgradeReady gets the pipe passed by the old process, if started by Upgrade.
+// It's called by loadInheritedListeners, before the variables are unset.
+func loadUpgradeReady() {
+	if !startedByUpgrade() {
+		return
+	}
+	if fd, err := strconv.Atoi(os.Getenv(upgradeReadyFdKey)); err == nil && fd >= listenFdsStart {
+		upgradeReady.file = os.NewFile(uintptr(fd), "upgrade-ready")
+	}
+}
+
+// notifyUpgradeReady tells the old process that this one started serving.
+func notifyUpgradeReady() {
+	upgradeReady.once.Do(func() {
+		loadInheritedListeners()
+		if upgradeReady.file != nil {
+			upgradeReady.file.Write([]byte{1})
+			upgradeReady.file.Close()
+		}
+	})
+}
+
+// startedByUpgrade reports whether the listeners were passed by Upgrade.
+func startedByUpgrade() bool {
+	ppid, err := strconv.Atoi(os.Getenv(upgradeParentPidKey))
+	return err == nil && ppid == os.Getppid() && len(os.Getenv(listenPidKey)) == 0
+}
+
+func inheritedListenerName(listener net.Listener) string {
+	inherited.mutex.Lock()
+	defer inherited.mutex.Unlock()
+	for _, il := range inherited.listeners {
+		if il.listener == listener {
+			return il.name
+		}
+	}
+	return upgradeListenName
+}